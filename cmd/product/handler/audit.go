@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"strconv"
+	"time"
+)
+
+// GetAuditLog lists audit entries, newest first, filtered by entity, entity_id, action, actor, request_id and
+// a from/to time range.
+func (h *ProductHandler) GetAuditLog(c *gin.Context) {
+	entityId, err := strconv.Atoi(c.DefaultQuery("entity_id", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid entity_id",
+		})
+		return
+	}
+
+	from, err := parseAuditTime(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid from, expected YYYY-MM-DD or RFC 3339",
+		})
+		return
+	}
+	to, err := parseAuditTime(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid to, expected YYYY-MM-DD or RFC 3339",
+		})
+		return
+	}
+
+	h.respondAuditEntries(c, models.AuditFilter{
+		Entity:    c.Query("entity"),
+		EntityID:  entityId,
+		Action:    c.Query("action"),
+		Actor:     c.Query("actor"),
+		RequestID: c.Query("request_id"),
+		From:      from,
+		To:        to,
+	})
+}
+
+// GetProductHistory lists the audit entries of one product, newest first.
+func (h *ProductHandler) GetProductHistory(c *gin.Context) {
+	productId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid product id",
+		})
+		return
+	}
+
+	h.respondAuditEntries(c, models.AuditFilter{
+		Entity:   models.AuditEntityProduct,
+		EntityID: productId,
+	})
+}
+
+func (h *ProductHandler) respondAuditEntries(c *gin.Context, filter models.AuditFilter) {
+	filter.Page, _ = strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	filter.Limit, _ = strconv.ParseInt(c.Query("page_size"), 10, 64)
+
+	entries, total, err := h.ProductUseCase.GetAuditEntries(c.Request.Context(), &filter)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"filter": filter,
+		}).Errorf("h.ProductUseCase.GetAuditEntries got an error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuditResponse{
+		Entries:    entries,
+		Page:       int(filter.Page),
+		PageSize:   int(filter.Limit),
+		TotalCount: total,
+		TotalPages: int((total + filter.Limit - 1) / filter.Limit),
+	})
+}
+
+// parseAuditTime reads a date or an RFC 3339 time, nil when empty.
+func parseAuditTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		parsed, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"strconv"
+	"time"
+)
+
+func (h *ProductHandler) ReconcileInventory(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		log.Logger.Errorf("c.FormFile(file) got an error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "missing count file",
+		})
+		return
+	}
+
+	countFile, err := fileHeader.Open()
+	if err != nil {
+		log.Logger.Errorf("fileHeader.Open() got an error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid count file",
+		})
+		return
+	}
+	defer countFile.Close()
+
+	// without an explicit confirmation the reconciliation is only a preview
+	apply := c.DefaultQuery("confirm", "false") == "true"
+
+	result, err := h.ProductUseCase.ReconcileInventory(c.Request.Context(), countFile, apply)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"file":  fileHeader.Filename,
+			"apply": apply,
+		}).Errorf("h.ProductUseCase.ReconcileInventory got an error: %v", err)
+		c.JSON(stockErrorStatus(err), gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reconciliation": result,
+	})
+}
+
+func (h *ProductHandler) GetInventorySnapshot(c *gin.Context) {
+	snapshotDate, err := time.Parse(time.DateOnly, c.Query("date"))
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"date": c.Query("date"),
+		}).Errorf("time.Parse(time.DateOnly, date): %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid date, expected YYYY-MM-DD",
+		})
+		return
+	}
+
+	snapshots, err := h.ProductUseCase.GetInventorySnapshot(c.Request.Context(), snapshotDate)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"date": c.Query("date"),
+		}).Errorf("h.ProductUseCase.GetInventorySnapshot got an error: %v", err)
+		c.JSON(snapshotErrorStatus(err), gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// the latest snapshot on or before the requested date
+	if len(snapshots) > 0 {
+		snapshotDate = snapshots[0].SnapshotDate
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"snapshot_date", "product_id", "product_name", "category_id", "stock", "unit_cost"}}
+		for _, snapshot := range snapshots {
+			rows = append(rows, []string{
+				snapshot.SnapshotDate.Format(time.DateOnly),
+				strconv.Itoa(snapshot.ProductID),
+				snapshot.ProductName,
+				strconv.Itoa(snapshot.CategoryID),
+				strconv.Itoa(snapshot.Stock),
+				strconv.FormatFloat(snapshot.UnitCost, 'f', 2, 64),
+			})
+		}
+		writeCSV(c, fmt.Sprintf("inventory-snapshot-%s.csv", snapshotDate.Format(time.DateOnly)), rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":      snapshotDate.Format(time.DateOnly),
+		"snapshots": snapshots,
+	})
+}
+
+func (h *ProductHandler) GetInventoryValuation(c *gin.Context) {
+	var snapshotDate *time.Time
+	if dateStr := c.Query("date"); dateStr != "" {
+		date, err := time.Parse(time.DateOnly, dateStr)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"date": dateStr,
+			}).Errorf("time.Parse(time.DateOnly, date): %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid date, expected YYYY-MM-DD",
+			})
+			return
+		}
+		snapshotDate = &date
+	}
+
+	report, err := h.ProductUseCase.GetInventoryValuation(c.Request.Context(), snapshotDate)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"date": c.Query("date"),
+		}).Errorf("h.ProductUseCase.GetInventoryValuation got an error: %v", err)
+		c.JSON(snapshotErrorStatus(err), gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"category_id", "category_name", "total_stock", "total_value"}}
+		for _, valuation := range report.Categories {
+			rows = append(rows, []string{
+				strconv.Itoa(valuation.CategoryID),
+				valuation.CategoryName,
+				strconv.Itoa(valuation.TotalStock),
+				strconv.FormatFloat(valuation.TotalValue, 'f', 2, 64),
+			})
+		}
+		rows = append(rows, []string{"", "total", "", strconv.FormatFloat(report.TotalValue, 'f', 2, 64)})
+		writeCSV(c, fmt.Sprintf("inventory-valuation-%s.csv", report.Date), rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valuation": report,
+	})
+}
+
+func snapshotErrorStatus(err error) int {
+	if errors.Is(err, models.ErrSnapshotNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeCSV(c *gin.Context, filename string, rows [][]string) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"filename": filename,
+		}).Errorf("writer.WriteAll got an error: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"strconv"
+)
+
+func (h *ProductHandler) LotManagement(c *gin.Context) {
+	var param models.LotManagementParameter
+	if err := c.ShouldBindJSON(&param); err != nil {
+		log.Logger.Error(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid input",
+		})
+		return
+	}
+
+	if param.Action == "" {
+		log.Logger.Error("missing parameter")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "missing parameter",
+		})
+		return
+	}
+
+	switch param.Action {
+	case "receive":
+		if param.ID != 0 || param.ProductID <= 0 || param.LotNumber == "" || param.Quantity <= 0 || param.ExpiresAt.IsZero() {
+			log.Logger.Error("invalid request - lot is incomplete")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid request",
+			})
+			return
+		}
+
+		product, err := h.ProductUseCase.ReceiveLot(c.Request.Context(), &param.ProductLot)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"param": param,
+			}).Errorf("h.ProductUseCase.ReceiveLot got an error: %v", err)
+			c.JSON(stockErrorStatus(err), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Successfully receive lot",
+			"lot":     param.ProductLot,
+			"product": product,
+		})
+		return
+
+	case "write_off":
+		if param.ID <= 0 {
+			log.Logger.Error("invalid request - lot id is not set")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid request",
+			})
+			return
+		}
+
+		product, err := h.ProductUseCase.WriteOffLot(c.Request.Context(), param.ID)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"param": param,
+			}).Errorf("h.ProductUseCase.WriteOffLot got an error: %v", err)
+			c.JSON(stockErrorStatus(err), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Successfully write off lot",
+			"product": product,
+		})
+		return
+
+	default:
+		log.Logger.Errorf("invalid action: %s", param.Action)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid input",
+		})
+		return
+	}
+}
+
+func (h *ProductHandler) GetExpiringLots(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		log.Logger.WithFields(logrus.Fields{
+			"days": c.Query("days"),
+		}).Error("invalid days parameter")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid days",
+		})
+		return
+	}
+
+	lots, err := h.ProductUseCase.GetExpiringLots(c.Request.Context(), days)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"days": days,
+		}).Errorf("h.ProductUseCase.GetExpiringLots got an error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days": days,
+		"lots": lots,
+	})
+}
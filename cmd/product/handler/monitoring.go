@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"product_commerce/models"
+	"sort"
+)
+
+// MonitoringHandler exposes the state of the service's connection pools.
+type MonitoringHandler struct {
+	Databases map[string]*sql.DB // by pool name, e.g. "primary", "replica-0"
+}
+
+func NewMonitoringHandler(databases map[string]*sql.DB) *MonitoringHandler {
+	return &MonitoringHandler{
+		Databases: databases,
+	}
+}
+
+func (h *MonitoringHandler) GetDatabaseStats(c *gin.Context) {
+	pools := make([]models.DatabasePoolStats, 0, len(h.Databases))
+	for name, database := range h.Databases {
+		pools = append(pools, models.NewDatabasePoolStats(name, database.Stats()))
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Name < pools[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"pools": pools,
+	})
+}
@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
 	"product_commerce/infra/log"
 	"product_commerce/models"
 	"strconv"
+	"strings"
 )
 
+const maxProductBatchSize = 100
+
 func (h *ProductHandler) ProductManagement(c *gin.Context) {
 	var param models.ProductManagementParameter
 	if err := c.ShouldBindJSON(&param); err != nil {
@@ -61,16 +66,58 @@ func (h *ProductHandler) ProductManagement(c *gin.Context) {
 			})
 			return
 		}
+
+		// the edit must name the version it is based on, by If-Match or by the version field
+		conflictStatus := http.StatusConflict
+		if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+			version, ok := parseProductETag(ifMatch)
+			if !ok {
+				log.Logger.Errorf("invalid request - malformed If-Match %q", ifMatch)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "invalid If-Match header",
+				})
+				return
+			}
+			param.Product.Version = version
+			conflictStatus = http.StatusPreconditionFailed
+		}
+		if param.Product.Version <= 0 {
+			log.Logger.Error("invalid request - product version is not set")
+			c.JSON(http.StatusPreconditionRequired, gin.H{
+				"message": "If-Match header or version is required",
+			})
+			return
+		}
+
 		product, err := h.ProductUseCase.UpdateProduct(c.Request.Context(), &param.Product)
 		if err != nil {
 			log.Logger.WithFields(logrus.Fields{
 				"param": param,
 			}).Errorf("h.ProductUseCase.UpdateProduct got an error: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{
+
+			if errors.Is(err, models.ErrVersionConflict) {
+				// the cache and the replicas may not have seen the write that won yet
+				current, errCurrent := h.ProductUseCase.GetCurrentProductById(c.Request.Context(), int64(param.Product.ID))
+				if errCurrent == nil && current.ID != 0 {
+					c.Header("ETag", productETag(current))
+				}
+				c.JSON(conflictStatus, gin.H{
+					"message": err.Error(),
+					"product": current,
+				})
+				return
+			}
+
+			status := http.StatusBadRequest
+			if errors.Is(err, models.ErrProductNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{
 				"message": err.Error(),
 			})
 			return
 		}
+		c.Header("ETag", productETag(product))
 		c.JSON(http.StatusCreated, gin.H{
 			"message":         "Successfully update product",
 			"productCategory": product,
@@ -90,8 +137,14 @@ func (h *ProductHandler) ProductManagement(c *gin.Context) {
 			log.Logger.WithFields(logrus.Fields{
 				"param": param,
 			}).Errorf("h.ProductUseCase.DeleteProduct got an error: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": err.Error(),
+			if errors.Is(err, models.ErrProductInUse) {
+				c.JSON(http.StatusConflict, gin.H{
+					"message": "product is a component of a bundle, remove it from the bundle first",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "failed to delete product",
 			})
 			return
 		}
@@ -100,7 +153,7 @@ func (h *ProductHandler) ProductManagement(c *gin.Context) {
 		})
 		return
 	default:
-		log.Logger.Error("invalid action: %s", param.Action)
+		log.Logger.Errorf("invalid action: %s", param.Action)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "invalid input",
 		})
@@ -124,8 +177,18 @@ func (h *ProductHandler) GetProductById(c *gin.Context) {
 	}
 
 	product, err := h.ProductUseCase.GetProductById(c.Request.Context(), productID)
+	if err != nil && !errors.Is(err, models.ErrProductNotFound) {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productID,
+		}).Errorf("h.ProductUseCase.GetProductById got an error: %v", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to get product",
+		})
+		return
+	}
 
-	if product.ID == 0 {
+	if err != nil || product.ID == 0 {
 		log.Logger.WithFields(logrus.Fields{
 			"product_id": productID,
 		}).Info("product not found")
@@ -135,13 +198,89 @@ func (h *ProductHandler) GetProductById(c *gin.Context) {
 		})
 		return
 	}
+	c.Header("ETag", productETag(product))
 	c.JSON(http.StatusOK, gin.H{
 		"product": product,
 	})
 }
 
+func productETag(product *models.Product) string {
+	return `"` + strconv.Itoa(product.Version) + `"`
+}
+
+// parseProductETag reads the version out of an ETag as written by productETag. Weak tags are accepted too.
+func parseProductETag(etag string) (int, bool) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(etag[1 : len(etag)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// GetProductsByIds returns many products at once. The ids come from the comma separated ids query parameter of
+// a GET or from the JSON body of a POST.
+func (h *ProductHandler) GetProductsByIds(c *gin.Context) {
+	var param models.ProductBatchParameter
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&param); err != nil {
+			log.Logger.Error(err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid input",
+			})
+			return
+		}
+	} else {
+		for _, productIdStr := range strings.Split(c.Query("ids"), ",") {
+			productIdStr = strings.TrimSpace(productIdStr)
+			if productIdStr == "" {
+				continue
+			}
+
+			productId, err := strconv.Atoi(productIdStr)
+			if err != nil {
+				log.Logger.WithFields(logrus.Fields{
+					"ids": c.Query("ids"),
+				}).Errorf("strconv.Atoi(productIdStr): %v", err)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "invalid input",
+				})
+				return
+			}
+			param.IDs = append(param.IDs, productId)
+		}
+	}
+
+	if len(param.IDs) == 0 || len(param.IDs) > maxProductBatchSize {
+		log.Logger.Errorf("invalid request - %d product ids requested", len(param.IDs))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("between 1 and %d product ids are required", maxProductBatchSize),
+		})
+		return
+	}
+
+	products, notFoundIds, err := h.ProductUseCase.GetProductsByIds(c.Request.Context(), param.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ProductBatchResponse{
+		Products: products,
+		NotFound: notFoundIds,
+	})
+}
+
 func (h *ProductHandler) SearchProduct(c *gin.Context) {
+	q := c.Query("q")
 	name := c.Query("name")
+	fuzzy, _ := strconv.ParseBool(c.Query("fuzzy"))
 	category := c.Query("category")
 
 	minPrice, _ := strconv.ParseFloat(c.Query("min_price"), 64)
@@ -154,7 +293,9 @@ func (h *ProductHandler) SearchProduct(c *gin.Context) {
 	PageSize, _ := strconv.ParseInt(c.DefaultQuery("page_size", "10"), 10, 64)
 
 	searchParam := models.SearchProductParameter{
+		Query:    q,
 		Name:     name,
+		Fuzzy:    fuzzy,
 		Category: category,
 		MinPrice: minPrice,
 		MaxPrice: maxPrice,
@@ -164,7 +305,7 @@ func (h *ProductHandler) SearchProduct(c *gin.Context) {
 		Page:     page,
 	}
 
-	products, total, err := h.ProductUseCase.SearchProduct(c.Request.Context(), &searchParam)
+	result, err := h.ProductUseCase.SearchProduct(c.Request.Context(), &searchParam)
 	if err != nil {
 		log.Logger.WithFields(logrus.Fields{
 			"param": searchParam,
@@ -176,24 +317,26 @@ func (h *ProductHandler) SearchProduct(c *gin.Context) {
 		return
 	}
 
+	total := result.TotalCount
 	totalPages := (total + PageSize - 1) / PageSize
 
 	var nextPageURL *string
 	if page < totalPages {
 		nextPage := page + 1
-		nextPageURLStr := fmt.Sprintf("/products?name=%s&category=%s&min_price=%f&max_price=%f&sort_by=%s&order_by=%s&page=%d&page_size=%d",
-			name, category, minPrice, maxPrice, sortBy, orderBy, nextPage, PageSize)
+		nextPageURLStr := fmt.Sprintf("/products?q=%s&name=%s&fuzzy=%t&category=%s&min_price=%f&max_price=%f&sort_by=%s&order_by=%s&page=%d&page_size=%d",
+			url.QueryEscape(q), name, fuzzy, category, minPrice, maxPrice, sortBy, orderBy, nextPage, PageSize)
 		nextPageURL = &nextPageURLStr
 	}
 
 	c.JSON(http.StatusOK, models.SearchProductResponse{
-		Products:    products,
+		Products:    result.Products,
 		Page:        int(page),
 		PageSize:    int(PageSize),
 		TotalCount:  total,
 		TotalPages:  int(totalPages),
 		NextPage:    nextPageURL != nil,
 		NextPageURL: nextPageURL,
+		DidYouMean:  result.DidYouMean,
 	})
 
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -120,11 +121,25 @@ func (h *ProductHandler) ProductCategoryManagement(c *gin.Context) {
 			return
 		}
 
-		err := h.ProductUseCase.DeleteProductCat(c.Request.Context(), param.ProductCategory.ID)
+		err := h.ProductUseCase.DeleteProductCat(c.Request.Context(), param.ProductCategory.ID, param.ReassignTo)
 		if err != nil {
 			log.Logger.WithFields(logrus.Fields{
 				"param": param,
 			}).Errorf("h.ProductUseCase.DeleteProductCat got an error: %v", err)
+			switch {
+			case errors.Is(err, models.ErrInvalidCategory):
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+			case errors.Is(err, models.ErrCategoryInUse):
+				c.JSON(http.StatusConflict, gin.H{
+					"message": "product category still has products, pass reassign_to to move them to another category",
+				})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "failed to delete product category",
+				})
+			}
 			return
 		}
 		c.JSON(http.StatusCreated, gin.H{
@@ -133,7 +148,7 @@ func (h *ProductHandler) ProductCategoryManagement(c *gin.Context) {
 		return
 
 	default:
-		log.Logger.Error("invalid action: %s", param.Action)
+		log.Logger.Errorf("invalid action: %s", param.Action)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "invalid input",
 		})
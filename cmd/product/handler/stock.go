@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+)
+
+func (h *ProductHandler) StockManagement(c *gin.Context) {
+	var param models.StockManagementParameter
+	if err := c.ShouldBindJSON(&param); err != nil {
+		log.Logger.Error(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid input",
+		})
+		return
+	}
+
+	if param.Action == "" || param.ProductID <= 0 {
+		log.Logger.Error("missing parameter")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "missing parameter",
+		})
+		return
+	}
+
+	var (
+		product *models.Product
+		err     error
+	)
+
+	switch param.Action {
+	case "adjust":
+		if param.Quantity == 0 || param.Reason == "" {
+			log.Logger.Error("invalid request - adjustment quantity or reason is not set")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid request",
+			})
+			return
+		}
+		product, err = h.ProductUseCase.AdjustStock(c.Request.Context(), &param)
+
+	case "reserve":
+		if param.Quantity <= 0 {
+			log.Logger.Error("invalid request - reservation quantity is not set")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid request",
+			})
+			return
+		}
+		product, err = h.ProductUseCase.ReserveStock(c.Request.Context(), param.ProductID, param.Quantity)
+
+	case "release":
+		if param.Quantity <= 0 {
+			log.Logger.Error("invalid request - release quantity is not set")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid request",
+			})
+			return
+		}
+		product, err = h.ProductUseCase.ReleaseStock(c.Request.Context(), param.ProductID, param.Quantity)
+
+	default:
+		log.Logger.Errorf("invalid action: %s", param.Action)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid input",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"param": param,
+		}).Errorf("h.ProductUseCase stock %s got an error: %v", param.Action, err)
+		c.JSON(stockErrorStatus(err), gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Successfully " + param.Action + " stock",
+		"product": product,
+	})
+}
+
+func stockErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrProductNotFound), errors.Is(err, models.ErrLotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInsufficientStock), errors.Is(err, models.ErrLotNotTracked):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,52 @@
+package job
+
+import (
+	"context"
+	"product_commerce/cmd/product/repository"
+	"product_commerce/infra/log"
+	"time"
+)
+
+const (
+	defaultInvalidationRetryInterval = 10 * time.Second
+	invalidationRetryBatchSize       = 100
+)
+
+// CacheInvalidationRetryJob replays cache invalidations that failed after their write committed.
+type CacheInvalidationRetryJob struct {
+	ProductStore *repository.CachedProductStore
+	Interval     time.Duration
+}
+
+func NewCacheInvalidationRetryJob(productStore *repository.CachedProductStore, interval time.Duration) *CacheInvalidationRetryJob {
+	if interval <= 0 {
+		interval = defaultInvalidationRetryInterval
+	}
+
+	return &CacheInvalidationRetryJob{
+		ProductStore: productStore,
+		Interval:     interval,
+	}
+}
+
+// Start retries due invalidations every interval until ctx is cancelled.
+func (j *CacheInvalidationRetryJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		applied, err := j.ProductStore.RetryCacheInvalidations(ctx, invalidationRetryBatchSize)
+		if err != nil {
+			log.Logger.Errorf("j.ProductStore.RetryCacheInvalidations() got error %v", err)
+		}
+		if applied > 0 {
+			log.Logger.Infof("%d queued cache invalidations applied", applied)
+		}
+	}
+}
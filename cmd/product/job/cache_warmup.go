@@ -0,0 +1,125 @@
+package job
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"product_commerce/cmd/product/repository"
+	"product_commerce/config"
+	"product_commerce/infra/log"
+	"time"
+)
+
+const (
+	defaultWarmupTopN      = 1000
+	defaultWarmupBatchSize = 100
+	defaultWarmupCriteria  = repository.TopProductsByNewest
+)
+
+type CacheWarmupReport struct {
+	Products   int           `json:"products"`
+	Categories int           `json:"categories"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// CacheWarmupJob loads the top products and every category from the store into the cache, so the first
+// requests after a cache flush or a deploy do not all reach the database.
+type CacheWarmupJob struct {
+	ProductStore repository.ProductStore
+	ProductCache repository.ProductCache
+	Config       config.WarmupConfig
+}
+
+func NewCacheWarmupJob(productStore repository.ProductStore, productCache repository.ProductCache, cfg config.WarmupConfig) *CacheWarmupJob {
+	if cfg.TopN <= 0 {
+		cfg.TopN = defaultWarmupTopN
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultWarmupBatchSize
+	}
+	if cfg.Criteria == "" {
+		cfg.Criteria = defaultWarmupCriteria
+	}
+
+	return &CacheWarmupJob{
+		ProductStore: productStore,
+		ProductCache: productCache,
+		Config:       cfg,
+	}
+}
+
+// Run warms the cache once. Products are read in batches and, when a rate is configured, no more than
+// BatchesPerSecond batches are read from the database per second.
+func (j *CacheWarmupJob) Run(ctx context.Context) (*CacheWarmupReport, error) {
+	startTime := time.Now()
+	report := &CacheWarmupReport{}
+
+	var throttle <-chan time.Time
+	if j.Config.BatchesPerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / j.Config.BatchesPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	wait := func() error {
+		if throttle == nil {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-throttle:
+			return nil
+		}
+	}
+
+	productCats, err := j.ProductStore.FindAllProductCats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.ProductCache.SetProductCats(ctx, productCats)
+	if err != nil {
+		return nil, err
+	}
+	report.Categories = len(productCats)
+	log.Logger.Infof("cache warm-up: %d categories cached", report.Categories)
+
+	err = wait()
+	if err != nil {
+		return nil, err
+	}
+
+	productIds, err := j.ProductStore.FindTopProductIds(ctx, j.Config.Criteria, j.Config.TopN)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(productIds); start += j.Config.BatchSize {
+		err = wait()
+		if err != nil {
+			return nil, err
+		}
+
+		end := min(start+j.Config.BatchSize, len(productIds))
+		products, err := j.ProductStore.FindProductsByIds(ctx, productIds[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		err = j.ProductCache.SetProducts(ctx, products)
+		if err != nil {
+			return nil, err
+		}
+		report.Products += len(products)
+
+		log.Logger.Infof("cache warm-up: %d/%d products cached", end, len(productIds))
+	}
+
+	report.Duration = time.Since(startTime)
+	log.Logger.WithFields(logrus.Fields{
+		"criteria":   j.Config.Criteria,
+		"products":   report.Products,
+		"categories": report.Categories,
+		"duration":   report.Duration,
+	}).Info("cache warm-up finished")
+	return report, nil
+}
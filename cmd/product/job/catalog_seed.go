@@ -0,0 +1,205 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+	"math/rand"
+	"product_commerce/cmd/product/repository"
+	"product_commerce/config"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"time"
+)
+
+const (
+	defaultSeedCategories = 20
+	defaultSeedProducts   = 1000
+	defaultSeedBatchSize  = 500
+
+	seedOutOfStockShare = 0.08
+	seedBackorderShare  = 0.07
+	seedMeanStock       = 60
+	seedMaxStock        = 1000
+)
+
+// seedDepartment is the template of generated categories: the kinds of products they hold and their price range.
+type seedDepartment struct {
+	name     string
+	nouns    []string
+	minPrice float64
+	maxPrice float64
+}
+
+var seedDepartments = []seedDepartment{
+	{"Kitchen", []string{"Chef Knife", "Frying Pan", "Kettle", "Cutting Board", "Saucepan", "Mixing Bowl"}, 5, 150},
+	{"Garden", []string{"Garden Hose", "Pruning Shears", "Rake", "Planter", "Watering Can", "Trowel"}, 3, 120},
+	{"Tools", []string{"Hammer", "Screwdriver Set", "Cordless Drill", "Wrench", "Tape Measure", "Spirit Level"}, 4, 250},
+	{"Office", []string{"Notebook", "Desk Lamp", "Stapler", "Office Chair", "Monitor Stand", "Pen Set"}, 2, 300},
+	{"Outdoor", []string{"Tent", "Sleeping Bag", "Backpack", "Headlamp", "Camping Stove", "Water Bottle"}, 8, 400},
+	{"Electronics", []string{"Headphones", "Bluetooth Speaker", "Power Bank", "USB Cable", "Webcam", "Keyboard"}, 6, 350},
+	{"Home", []string{"Throw Pillow", "Wall Clock", "Candle", "Picture Frame", "Rug", "Vase"}, 5, 200},
+	{"Sports", []string{"Yoga Mat", "Dumbbell", "Jump Rope", "Football", "Tennis Racket", "Cycling Gloves"}, 5, 180},
+	{"Toys", []string{"Puzzle", "Building Blocks", "Plush Bear", "Board Game", "Kite", "Toy Car"}, 4, 90},
+	{"Pet Supplies", []string{"Dog Leash", "Cat Tree", "Pet Bed", "Food Bowl", "Chew Toy", "Litter Box"}, 3, 160},
+}
+
+var (
+	seedAdjectives = []string{"Classic", "Compact", "Premium", "Everyday", "Heavy-Duty", "Eco", "Deluxe", "Lightweight", "Pro", "Essential"}
+	seedMaterials  = []string{"Steel", "Bamboo", "Cotton", "Aluminium", "Ceramic", "Recycled", "Oak", "Silicone"}
+	seedFeatures   = []string{
+		"built to last", "easy to clean", "ideal for daily use", "backed by a two-year warranty",
+		"designed for small spaces", "made from sustainable materials", "a customer favourite", "great as a gift",
+	}
+)
+
+type CatalogSeedReport struct {
+	Categories int           `json:"categories"`
+	Products   int           `json:"products"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// CatalogSeedJob fills the catalog with synthetic categories and products for demos and load tests. Products
+// are spread unevenly over the categories, with prices in the range of their category and mostly modest stock.
+type CatalogSeedJob struct {
+	ProductStore repository.ProductStore
+	Config       config.SeedConfig
+}
+
+func NewCatalogSeedJob(productStore repository.ProductStore, cfg config.SeedConfig) *CatalogSeedJob {
+	if cfg.Categories <= 0 {
+		cfg.Categories = defaultSeedCategories
+	}
+	if cfg.Products <= 0 {
+		cfg.Products = defaultSeedProducts
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultSeedBatchSize
+	}
+
+	return &CatalogSeedJob{
+		ProductStore: productStore,
+		Config:       cfg,
+	}
+}
+
+// Run inserts the categories and then the products, one batch per transaction. A failed batch stops the run
+// and leaves the batches before it in place.
+func (j *CatalogSeedJob) Run(ctx context.Context) (*CatalogSeedReport, error) {
+	startTime := time.Now()
+	report := &CatalogSeedReport{}
+	rng := rand.New(rand.NewSource(j.Config.Seed))
+
+	productCats := make([]models.ProductCategory, j.Config.Categories)
+	for i := range productCats {
+		productCats[i].Name = seedCategoryName(i)
+	}
+	for start := 0; start < len(productCats); start += j.Config.BatchSize {
+		end := min(start+j.Config.BatchSize, len(productCats))
+		err := j.ProductStore.InsertNewProductCats(ctx, productCats[start:end])
+		if err != nil {
+			return nil, err
+		}
+		report.Categories = end
+	}
+	log.Logger.Infof("catalog seed: %d categories created", report.Categories)
+
+	// a few categories hold most of the products, like in a real catalog
+	weights := make([]float64, len(productCats))
+	totalWeight := 0.0
+	for i := range weights {
+		weights[i] = 1 / float64(i+1)
+		totalWeight += weights[i]
+	}
+
+	for start := 0; start < j.Config.Products; start += j.Config.BatchSize {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		end := min(start+j.Config.BatchSize, j.Config.Products)
+		products := make([]models.Product, 0, end-start)
+		for range end - start {
+			categoryIndex := pickWeighted(rng, weights, totalWeight)
+			products = append(products, seedProduct(rng, categoryIndex, productCats[categoryIndex].ID))
+		}
+
+		err = j.ProductStore.InsertNewProducts(ctx, products)
+		if err != nil {
+			return nil, err
+		}
+		report.Products = end
+
+		log.Logger.Infof("catalog seed: %d/%d products created", end, j.Config.Products)
+	}
+
+	report.Duration = time.Since(startTime)
+	log.Logger.WithFields(logrus.Fields{
+		"seed":       j.Config.Seed,
+		"categories": report.Categories,
+		"products":   report.Products,
+		"duration":   report.Duration,
+	}).Info("catalog seed finished")
+	return report, nil
+}
+
+// seedCategoryName names categories after the departments, numbering them once every department is used.
+func seedCategoryName(index int) string {
+	name := seedDepartments[index%len(seedDepartments)].name
+	if round := index / len(seedDepartments); round > 0 {
+		return fmt.Sprintf("%s %d", name, round+1)
+	}
+	return name
+}
+
+func seedProduct(rng *rand.Rand, categoryIndex int, categoryId int) models.Product {
+	department := seedDepartments[categoryIndex%len(seedDepartments)]
+
+	name := department.nouns[rng.Intn(len(department.nouns))]
+	if rng.Intn(2) == 0 {
+		name = seedMaterials[rng.Intn(len(seedMaterials))] + " " + name
+	}
+	name = seedAdjectives[rng.Intn(len(seedAdjectives))] + " " + name
+	if rng.Intn(3) == 0 {
+		name = fmt.Sprintf("%s %c%d", name, 'A'+rng.Intn(26), 100+rng.Intn(900))
+	}
+
+	first := rng.Intn(len(seedFeatures))
+	second := (first + 1 + rng.Intn(len(seedFeatures)-1)) % len(seedFeatures)
+	description := fmt.Sprintf("The %s is %s and %s.", name, seedFeatures[first], seedFeatures[second])
+
+	// prices spread evenly on a log scale end in .99, costs are 35-70% of the price
+	logMin, logMax := math.Log(department.minPrice), math.Log(department.maxPrice)
+	price := math.Floor(math.Exp(logMin+rng.Float64()*(logMax-logMin))) + 0.99
+	cost := math.Round(price*(0.35+rng.Float64()*0.35)*100) / 100
+
+	product := models.Product{
+		Name:            name,
+		Description:     description,
+		CategoryID:      categoryId,
+		Price:           price,
+		Cost:            cost,
+		InventoryPolicy: models.InventoryPolicyDeny,
+	}
+	if rng.Float64() >= seedOutOfStockShare {
+		product.Stock = min(int(rng.ExpFloat64()*seedMeanStock)+1, seedMaxStock)
+	}
+	if rng.Float64() < seedBackorderShare {
+		product.InventoryPolicy = models.InventoryPolicyBackorder
+		product.BackorderLimit = 10 + rng.Intn(41)
+	}
+	return product
+}
+
+func pickWeighted(rng *rand.Rand, weights []float64, totalWeight float64) int {
+	target := rng.Float64() * totalWeight
+	for i, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
@@ -0,0 +1,66 @@
+package job
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"product_commerce/cmd/product/usecase"
+	"product_commerce/infra/log"
+	"time"
+)
+
+const defaultSnapshotTime = "23:55"
+
+type InventorySnapshotJob struct {
+	ProductUseCase usecase.ProductUseCase
+	SnapshotTime   string
+}
+
+func NewInventorySnapshotJob(productUseCase usecase.ProductUseCase, snapshotTime string) *InventorySnapshotJob {
+	if snapshotTime == "" {
+		snapshotTime = defaultSnapshotTime
+	}
+
+	return &InventorySnapshotJob{
+		ProductUseCase: productUseCase,
+		SnapshotTime:   snapshotTime,
+	}
+}
+
+// Start captures an inventory snapshot every day at the configured time until ctx is cancelled.
+func (j *InventorySnapshotJob) Start(ctx context.Context) {
+	runAt, err := time.Parse("15:04", j.SnapshotTime)
+	if err != nil {
+		log.Logger.Errorf("invalid inventory snapshot time %q, using %s: %v", j.SnapshotTime, defaultSnapshotTime, err)
+		runAt, _ = time.Parse("15:04", defaultSnapshotTime)
+	}
+
+	for {
+		next := nextRun(time.Now(), runAt)
+		log.Logger.Infof("next inventory snapshot scheduled at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		captured, err := j.ProductUseCase.CaptureInventorySnapshot(ctx, next)
+		if err != nil {
+			continue
+		}
+		log.Logger.WithFields(logrus.Fields{
+			"snapshot_date": next.Format(time.DateOnly),
+			"products":      captured,
+		}).Info("inventory snapshot captured")
+	}
+}
+
+func nextRun(now time.Time, runAt time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), runAt.Hour(), runAt.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
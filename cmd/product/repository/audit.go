@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"gorm.io/gorm"
+	"product_commerce/models"
+	"reflect"
+	"time"
+)
+
+// auditIgnoredFields are derived when an entity is read and are never written, so they are left out of diffs.
+var auditIgnoredFields = map[string]bool{
+	"availability": true,
+	"rank":         true,
+	"name_snippet": true,
+	"snippet":      true,
+}
+
+// recordAudit writes an audit entry for the change of an entity from before to after in tx, so the entry
+// commits or rolls back together with the change. before is nil for a create and after is nil for a delete.
+// The actor, whether it was verified, and the request id are taken from ctx.
+func recordAudit(ctx context.Context, tx *gorm.DB, entity string, entityId int, action string, before interface{}, after interface{}) error {
+	entry, err := newAuditEntry(ctx, entity, entityId, action, before, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Table("audit_log").Create(entry).Error
+}
+
+func newAuditEntry(ctx context.Context, entity string, entityId int, action string, before interface{}, after interface{}) (*models.AuditEntry, error) {
+	changes, err := diffFields(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// changes made outside of a request are the system's own; a request's actor is trusted only when verified
+	actor, _ := ctx.Value("actor").(string)
+	actorVerified, _ := ctx.Value("actor_verified").(bool)
+	if actor == "" {
+		actor = models.AuditActorSystem
+		actorVerified = true
+	}
+	requestId, _ := ctx.Value("request_id").(string)
+
+	return &models.AuditEntry{
+		Entity:        entity,
+		EntityID:      entityId,
+		Action:        action,
+		Actor:         actor,
+		ActorVerified: actorVerified,
+		RequestID:     requestId,
+		Changes:       changes,
+		CreatedAt:     time.Now(),
+	}, nil
+}
+
+// diffFields compares the JSON fields of two values of the same entity and returns the fields that differ.
+func diffFields(before interface{}, after interface{}) (models.AuditChanges, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(models.AuditChanges)
+	for field, beforeValue := range beforeFields {
+		afterValue := afterFields[field]
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			changes[field] = models.FieldChange{Before: beforeValue, After: afterValue}
+		}
+	}
+	for field, afterValue := range afterFields {
+		if _, ok := beforeFields[field]; !ok && afterValue != nil {
+			changes[field] = models.FieldChange{After: afterValue}
+		}
+	}
+	return changes, nil
+}
+
+// jsonFields returns the fields of value by their JSON name, none for a nil value.
+func jsonFields(value interface{}) (map[string]interface{}, error) {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(valueJson, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for field := range auditIgnoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// FindAuditEntries returns one page of the audit entries matching the filter, newest first, and the number of
+// all matching entries.
+func (r *ProductRepository) FindAuditEntries(ctx context.Context, filter *models.AuditFilter) ([]models.AuditEntry, int64, error) {
+	query := r.reader(ctx).Table("audit_log")
+	if filter.Entity != "" {
+		query = query.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var totalCount int64
+	err := query.Count(&totalCount).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter.Normalize()
+	var entries []models.AuditEntry
+	err = query.Order("id DESC").
+		Offset(int((filter.Page - 1) * filter.Limit)).
+		Limit(int(filter.Limit)).
+		Find(&entries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalCount, nil
+}
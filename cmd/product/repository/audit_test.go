@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"product_commerce/models"
+	"reflect"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	before := &models.Product{ID: 1, Name: "Hammer", Price: 9.99, Stock: 5, Version: 1, Availability: models.AvailabilityInStock}
+	after := &models.Product{ID: 1, Name: "Hammer XL", Price: 9.99, Stock: 5, Version: 2, Availability: models.AvailabilityOutOfStock}
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   models.AuditChanges
+	}{
+		{
+			name:   "update keeps changed fields only and skips derived ones",
+			before: before,
+			after:  after,
+			want: models.AuditChanges{
+				"name":    {Before: "Hammer", After: "Hammer XL"},
+				"version": {Before: float64(1), After: float64(2)},
+			},
+		},
+		{
+			name:   "create lists every set field",
+			before: nil,
+			after:  &models.ProductCategory{ID: 3, Name: "Tools"},
+			want: models.AuditChanges{
+				"id":   {After: float64(3)},
+				"name": {After: "Tools"},
+			},
+		},
+		{
+			name:   "delete lists every field as removed",
+			before: &models.ProductCategory{ID: 3, Name: "Tools"},
+			after:  nil,
+			want: models.AuditChanges{
+				"id":   {Before: float64(3)},
+				"name": {Before: "Tools"},
+			},
+		},
+		{
+			name:   "no change",
+			before: before,
+			after:  before,
+			want:   models.AuditChanges{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diffFields(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("diffFields() got error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"sort"
+	"time"
+)
+
+// CachedProductStore decorates a ProductStore with cache-aside reads. Reads are served from the cache when
+// possible and filled from the store on a miss; every write invalidates the entries it touches. Reads inside a
+// transaction go straight to the store, so they see the transaction's own writes and never cache them.
+type CachedProductStore struct {
+	ProductStore
+	Cache ProductCache
+
+	loads      singleflight.Group
+	replicaLag time.Duration
+}
+
+const (
+	productLoadTimeout      = 2 * time.Second
+	productLockWaitAttempts = 5
+	productLockWaitInterval = 50 * time.Millisecond
+
+	cacheInvalidationRetryDelay    = 5 * time.Second
+	cacheInvalidationMaxRetryDelay = 5 * time.Minute
+)
+
+// NewCachedProductStore caches the reads of store. replicaLag is the most the store's read replicas may lag
+// behind, zero when it reads from the primary only.
+func NewCachedProductStore(store ProductStore, cache ProductCache, replicaLag time.Duration) *CachedProductStore {
+	return &CachedProductStore{
+		ProductStore: store,
+		Cache:        cache,
+		replicaLag:   replicaLag,
+	}
+}
+
+// FindByProductId serves products cache-aside while protecting the store from stampedes: concurrent misses in
+// this instance share one load, a Redis lock lets a single instance load at a time, and an expired product
+// keeps being served while it is refreshed in the background.
+func (s *CachedProductStore) FindByProductId(ctx context.Context, productId int64) (*models.Product, error) {
+	if inTransaction(ctx) {
+		return s.ProductStore.FindByProductId(ctx, productId)
+	}
+
+	product, stale, err := s.Cache.GetProductById(ctx, productId)
+	if errors.Is(err, ErrNotFoundCached) {
+		return &models.Product{}, nil
+	}
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productId,
+		}).Errorf("s.Cache.GetProductById() got error %v", err)
+	}
+
+	if product != nil && product.ID != 0 {
+		if stale {
+			go s.refreshProduct(ctx, productId)
+		}
+		return product, nil
+	}
+
+	loaded, err, _ := s.loads.Do(productCacheKey(productId), func() (interface{}, error) {
+		return s.loadProduct(ctx, productId, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every caller sharing the load gets its own copy, apart from the one handed to the cache
+	return cloneProduct(loaded.(*models.Product)), nil
+}
+
+func (s *CachedProductStore) refreshProduct(ctx context.Context, productId int64) {
+	_, err, _ := s.loads.Do("refresh:"+productCacheKey(productId), func() (interface{}, error) {
+		return s.loadProduct(ctx, productId, false)
+	})
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productId,
+		}).Errorf("s.refreshProduct() got error %v", err)
+	}
+}
+
+// loadProduct reads a product from the store and caches it under a lock shared by all instances. When another
+// instance holds the lock, a miss waits briefly for that instance to fill the cache, while a refresh of a
+// stale product is simply left to it.
+func (s *CachedProductStore) loadProduct(ctx context.Context, productId int64, waitForLock bool) (*models.Product, error) {
+	// the load is shared by several callers, so it must not be cut short when the first one goes away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), productLoadTimeout)
+	defer cancel()
+
+	lockName := productCacheKey(productId)
+	token, acquired, err := s.Cache.AcquireLock(ctx, lockName)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productId,
+		}).Errorf("s.Cache.AcquireLock() got error %v", err)
+	}
+
+	if acquired {
+		defer func() {
+			errRelease := s.Cache.ReleaseLock(ctx, lockName, token)
+			if errRelease != nil {
+				log.Logger.WithFields(logrus.Fields{
+					"product_id": productId,
+				}).Errorf("s.Cache.ReleaseLock() got error %v", errRelease)
+			}
+		}()
+	} else if err == nil {
+		if !waitForLock {
+			return nil, nil
+		}
+
+		for i := 0; i < productLockWaitAttempts; i++ {
+			time.Sleep(productLockWaitInterval)
+
+			product, _, errCache := s.Cache.GetProductById(ctx, productId)
+			if errors.Is(errCache, ErrNotFoundCached) {
+				return &models.Product{}, nil
+			}
+			if errCache == nil && product.ID != 0 {
+				return product, nil
+			}
+		}
+	}
+
+	product, err := s.ProductStore.FindByProductId(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if product.ID == 0 {
+		err = s.Cache.SetProductNotFound(ctx, productId)
+	} else {
+		err = s.Cache.SetProductById(ctx, product)
+	}
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productId,
+		}).Errorf("CachedProductStore loadProduct cache write got error %v", err)
+	}
+	return product, nil
+}
+
+// FindProductsByIds reads all cached products in one round trip and loads the misses with a single query. The
+// loaded products are written back to the cache together. Like the store, it returns the products ordered by id
+// and leaves out ids that do not exist.
+func (s *CachedProductStore) FindProductsByIds(ctx context.Context, productIds []int) ([]models.Product, error) {
+	if inTransaction(ctx) {
+		return s.ProductStore.FindProductsByIds(ctx, productIds)
+	}
+
+	cached, notFoundIds, err := s.Cache.GetProducts(ctx, productIds)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_ids": productIds,
+		}).Errorf("s.Cache.GetProducts() got error %v", err)
+		return s.ProductStore.FindProductsByIds(ctx, productIds)
+	}
+
+	notFound := make(map[int]bool, len(notFoundIds))
+	for _, productId := range notFoundIds {
+		notFound[productId] = true
+	}
+
+	products := make([]models.Product, 0, len(productIds))
+	var missedIds []int
+	for _, productId := range productIds {
+		if product, ok := cached[productId]; ok {
+			products = append(products, product)
+		} else if !notFound[productId] {
+			missedIds = append(missedIds, productId)
+		}
+	}
+
+	if len(missedIds) > 0 {
+		loaded, err := s.ProductStore.FindProductsByIds(ctx, missedIds)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(loaded) > 0 {
+			cached := cloneProducts(loaded)
+			s.fill(ctx, func(ctx context.Context) error {
+				return s.Cache.SetProducts(ctx, cached)
+			})
+		}
+		products = append(products, loaded...)
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ID < products[j].ID
+	})
+	return products, nil
+}
+
+func (s *CachedProductStore) FindProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
+	if inTransaction(ctx) {
+		return s.ProductStore.FindProductCatById(ctx, productCatId)
+	}
+
+	productCat, err := s.Cache.GetProductCatById(ctx, productCatId)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_cat_id": productCatId,
+		}).Errorf("s.Cache.GetProductCatById() got error %v", err)
+	}
+
+	if productCat != nil && productCat.ID != 0 {
+		return productCat, nil
+	}
+
+	productCat, err = s.ProductStore.FindProductCatById(ctx, productCatId)
+	if err != nil {
+		return nil, err
+	}
+
+	if productCat.ID != 0 {
+		cached := *productCat
+		s.fill(ctx, func(ctx context.Context) error {
+			return s.Cache.SetProductCatById(ctx, &cached)
+		})
+	}
+	return productCat, nil
+}
+
+// SearchProducts caches result pages under the current catalog version. Every catalog write bumps the version,
+// which retires all cached pages at once. The version is read before the store is queried, so a page can only
+// be cached under a version that is at least as old as the data it holds.
+func (s *CachedProductStore) SearchProducts(ctx context.Context, searchParam *models.SearchProductParameter) ([]models.Product, int64, error) {
+	if inTransaction(ctx) {
+		return s.ProductStore.SearchProducts(ctx, searchParam)
+	}
+
+	searchParam.Normalize()
+	searchKey := searchCacheKey(searchParam)
+
+	catalogVersion, err := s.Cache.GetCatalogVersion(ctx)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"searchParam": searchParam,
+		}).Errorf("s.Cache.GetCatalogVersion() got error %v", err)
+		return s.ProductStore.SearchProducts(ctx, searchParam)
+	}
+
+	result, err := s.Cache.GetSearchResult(ctx, catalogVersion, searchKey)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"searchParam": searchParam,
+		}).Errorf("s.Cache.GetSearchResult() got error %v", err)
+	}
+
+	if result != nil && result.Products != nil {
+		return result.Products, result.TotalCount, nil
+	}
+
+	products, total, err := s.ProductStore.SearchProducts(ctx, searchParam)
+	if err != nil {
+		return products, total, err
+	}
+
+	if products == nil {
+		products = []models.Product{}
+	}
+	cached := cloneProducts(products)
+	s.fill(ctx, func(ctx context.Context) error {
+		return s.Cache.SetSearchResult(ctx, catalogVersion, searchKey, &models.SearchProductResult{
+			Products:   cached,
+			TotalCount: total,
+		})
+	})
+	return products, total, nil
+}
+
+func (s *CachedProductStore) InsertNewProduct(ctx context.Context, product *models.Product) (int, error) {
+	id, err := s.ProductStore.InsertNewProduct(ctx, product)
+	if err != nil {
+		return 0, err
+	}
+
+	// the new id may have been probed before and remembered as missing
+	s.invalidateProducts(ctx, id)
+	return id, nil
+}
+
+func (s *CachedProductStore) InsertNewProductCat(ctx context.Context, productCat *models.ProductCategory) (int, error) {
+	id, err := s.ProductStore.InsertNewProductCat(ctx, productCat)
+	if err != nil {
+		return 0, err
+	}
+
+	s.invalidateProductCats(ctx)
+	return id, nil
+}
+
+func (s *CachedProductStore) InsertNewProducts(ctx context.Context, products []models.Product) error {
+	err := s.ProductStore.InsertNewProducts(ctx, products)
+	if err != nil {
+		return err
+	}
+
+	productIds := make([]int, len(products))
+	for i := range products {
+		productIds[i] = products[i].ID
+	}
+	s.invalidateProducts(ctx, productIds...)
+	return nil
+}
+
+func (s *CachedProductStore) InsertNewProductCats(ctx context.Context, productCats []models.ProductCategory) error {
+	err := s.ProductStore.InsertNewProductCats(ctx, productCats)
+	if err != nil {
+		return err
+	}
+
+	s.invalidateProductCats(ctx)
+	return nil
+}
+
+func (s *CachedProductStore) UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
+	product, err := s.ProductStore.UpdateProduct(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateProducts(ctx, product.ID)
+	return product, nil
+}
+
+func (s *CachedProductStore) UpdateProductCat(ctx context.Context, productCat *models.ProductCategory) (*models.ProductCategory, error) {
+	productCat, err := s.ProductStore.UpdateProductCat(ctx, productCat)
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateProductCats(ctx, productCat.ID)
+	return productCat, nil
+}
+
+func (s *CachedProductStore) DeleteProduct(ctx context.Context, id int) error {
+	err := s.ProductStore.DeleteProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.invalidateProducts(ctx, id)
+	return nil
+}
+
+func (s *CachedProductStore) DeleteProductCat(ctx context.Context, id int) error {
+	err := s.ProductStore.DeleteProductCat(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.invalidateProductCats(ctx, id)
+	return nil
+}
+
+func (s *CachedProductStore) ReassignProductsCategory(ctx context.Context, fromCatId int, toCatId int) ([]int, error) {
+	productIds, err := s.ProductStore.ReassignProductsCategory(ctx, fromCatId, toCatId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateProducts(ctx, productIds...)
+	return productIds, nil
+}
+
+func (s *CachedProductStore) AdjustProductStock(ctx context.Context, productId int, quantity int, reason string) (*models.Product, error) {
+	product, err := s.ProductStore.AdjustProductStock(ctx, productId, quantity, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	touchedIds := []int{productId}
+	for _, component := range product.Components {
+		touchedIds = append(touchedIds, component.ComponentID)
+	}
+	s.invalidateProducts(ctx, touchedIds...)
+	return product, nil
+}
+
+func (s *CachedProductStore) ReconcileStock(ctx context.Context, counts []models.StockCount, apply bool) (*models.StockReconciliation, error) {
+	result, err := s.ProductStore.ReconcileStock(ctx, counts, apply)
+	if err != nil || !apply {
+		return result, err
+	}
+
+	var touchedIds []int
+	for _, item := range result.Items {
+		if item.Difference != 0 {
+			touchedIds = append(touchedIds, item.ProductID)
+		}
+	}
+	s.invalidateProducts(ctx, touchedIds...)
+	return result, nil
+}
+
+func (s *CachedProductStore) ReceiveLot(ctx context.Context, lot *models.ProductLot) (*models.Product, error) {
+	product, err := s.ProductStore.ReceiveLot(ctx, lot)
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateProducts(ctx, product.ID)
+	return product, nil
+}
+
+func (s *CachedProductStore) WriteOffLot(ctx context.Context, lotId int) (*models.Product, error) {
+	product, err := s.ProductStore.WriteOffLot(ctx, lotId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateProducts(ctx, product.ID)
+	return product, nil
+}
+
+// fill writes a cache entry in the background so the read that missed does not wait for the cache. The caller
+// goes on to resolve the products it returns, so set must only capture copies of them, see cloneProducts.
+func (s *CachedProductStore) fill(ctx context.Context, set func(ctx context.Context) error) {
+	ctxConcurrent := context.WithValue(context.Background(), "request_id", ctx.Value("request_id"))
+	go func(ctx context.Context) {
+		errConcurrent := set(ctx)
+		if errConcurrent != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"request_id": ctx.Value("request_id"),
+			}).Errorf("CachedProductStore fill got error %v", errConcurrent)
+		}
+	}(ctxConcurrent)
+}
+
+// invalidateProducts drops cached products and search results once the write has committed.
+func (s *CachedProductStore) invalidateProducts(ctx context.Context, productIds ...int) {
+	if len(productIds) == 0 {
+		return
+	}
+
+	invalidations := make([]models.PendingCacheInvalidation, 0, len(productIds)+1)
+	for _, productId := range productIds {
+		invalidations = append(invalidations, models.PendingCacheInvalidation{Entity: models.CacheEntityProduct, EntityID: productId})
+	}
+	invalidations = append(invalidations, models.PendingCacheInvalidation{Entity: models.CacheEntityCatalogVersion})
+	s.invalidateAfterCommit(ctx, invalidations)
+}
+
+func (s *CachedProductStore) invalidateProductCats(ctx context.Context, productCatIds ...int) {
+	invalidations := make([]models.PendingCacheInvalidation, 0, len(productCatIds)+1)
+	for _, productCatId := range productCatIds {
+		invalidations = append(invalidations, models.PendingCacheInvalidation{Entity: models.CacheEntityProductCat, EntityID: productCatId})
+	}
+	invalidations = append(invalidations, models.PendingCacheInvalidation{Entity: models.CacheEntityCatalogVersion})
+	s.invalidateAfterCommit(ctx, invalidations)
+}
+
+// invalidateAfterCommit applies the invalidations once the transaction in ctx has committed, so a rolled back
+// write never touches the cache. With read replicas they are applied a second time once the replicas have caught
+// up, dropping anything a lagging replica let back into the cache in between.
+func (s *CachedProductStore) invalidateAfterCommit(ctx context.Context, invalidations []models.PendingCacheInvalidation) {
+	AfterCommit(ctx, func(ctx context.Context) {
+		s.applyInvalidations(ctx, invalidations)
+
+		if s.replicaLag > 0 {
+			ctxDelayed := context.WithoutCancel(ctx)
+			time.AfterFunc(s.replicaLag, func() {
+				s.applyInvalidations(ctxDelayed, invalidations)
+			})
+		}
+	})
+}
+
+// applyInvalidations runs after the write has succeeded, so invalidations the cache rejects are queued in the
+// store and retried by RetryCacheInvalidations instead of being returned.
+func (s *CachedProductStore) applyInvalidations(ctx context.Context, invalidations []models.PendingCacheInvalidation) {
+	var failed []models.PendingCacheInvalidation
+	for _, invalidation := range invalidations {
+		err := s.invalidate(ctx, invalidation)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"entity":    invalidation.Entity,
+				"entity_id": invalidation.EntityID,
+			}).Errorf("s.invalidate() got error %v", err)
+
+			invalidation.Attempts = 1
+			invalidation.LastError = err.Error()
+			invalidation.NextAttemptAt = time.Now().Add(cacheInvalidationBackoff(invalidation.Attempts))
+			failed = append(failed, invalidation)
+		}
+	}
+
+	err := s.ProductStore.EnqueueCacheInvalidations(ctx, failed)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"invalidations": failed,
+		}).Errorf("s.ProductStore.EnqueueCacheInvalidations() got error %v", err)
+	}
+}
+
+func (s *CachedProductStore) invalidate(ctx context.Context, invalidation models.PendingCacheInvalidation) error {
+	switch invalidation.Entity {
+	case models.CacheEntityProduct:
+		return s.Cache.DeleteProductCache(ctx, invalidation.EntityID)
+	case models.CacheEntityProductCat:
+		return s.Cache.DeleteProductCatCache(ctx, invalidation.EntityID)
+	case models.CacheEntityCatalogVersion:
+		return s.Cache.BumpCatalogVersion(ctx)
+	default:
+		return fmt.Errorf("unknown cache entity %q", invalidation.Entity)
+	}
+}
+
+// RetryCacheInvalidations applies queued invalidations that are due and returns how many succeeded. Those that
+// fail again are pushed back with a growing delay.
+func (s *CachedProductStore) RetryCacheInvalidations(ctx context.Context, limit int) (int, error) {
+	invalidations, err := s.ProductStore.FindDueCacheInvalidations(ctx, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for i := range invalidations {
+		invalidation := &invalidations[i]
+		err = s.invalidate(ctx, *invalidation)
+		if err != nil {
+			invalidation.Attempts++
+			invalidation.LastError = err.Error()
+			invalidation.NextAttemptAt = time.Now().Add(cacheInvalidationBackoff(invalidation.Attempts))
+
+			err = s.ProductStore.RescheduleCacheInvalidation(ctx, invalidation)
+			if err != nil {
+				return applied, err
+			}
+			continue
+		}
+
+		err = s.ProductStore.DeleteCacheInvalidation(ctx, invalidation.ID)
+		if err != nil {
+			return applied, err
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+func cacheInvalidationBackoff(attempts int) time.Duration {
+	backoff := cacheInvalidationRetryDelay << min(attempts-1, 16)
+	return min(backoff, cacheInvalidationMaxRetryDelay)
+}
+
+func searchCacheKey(searchParam *models.SearchProductParameter) string {
+	paramJson, _ := json.Marshal(searchParam)
+	hash := sha1.Sum(paramJson)
+	return hex.EncodeToString(hash[:])
+}
+
+// cloneProduct copies a product deep enough that resolving the copy, i.e. setting its components, stock and
+// availability, leaves the original untouched.
+func cloneProduct(product *models.Product) *models.Product {
+	clone := *product
+	if product.Components != nil {
+		clone.Components = make([]models.BundleComponent, len(product.Components))
+		for i, component := range product.Components {
+			if component.Product != nil {
+				component.Product = cloneProduct(component.Product)
+			}
+			clone.Components[i] = component
+		}
+	}
+	return &clone
+}
+
+func cloneProducts(products []models.Product) []models.Product {
+	clones := make([]models.Product, len(products))
+	for i := range products {
+		clones[i] = *cloneProduct(&products[i])
+	}
+	return clones
+}
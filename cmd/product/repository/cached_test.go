@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"product_commerce/config"
+	"product_commerce/models"
+	"testing"
+	"time"
+)
+
+// searchStore serves a fixed page of products to searches; any other call of the store panics.
+type searchStore struct {
+	ProductStore
+	products []models.Product
+}
+
+func (s *searchStore) SearchProducts(ctx context.Context, searchParam *models.SearchProductParameter) ([]models.Product, int64, error) {
+	return cloneProducts(s.products), int64(len(s.products)), nil
+}
+
+func TestSearchProductsCachesPageAsLoaded(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(config.CacheTTLConfig{})
+	store := NewCachedProductStore(&searchStore{products: []models.Product{
+		{ID: 1, Name: "Kit", Stock: 0, IsBundle: true},
+	}}, cache, 0)
+
+	searchParam := &models.SearchProductParameter{Name: "Kit"}
+	products, _, err := store.SearchProducts(ctx, searchParam)
+	if err != nil {
+		t.Fatalf("SearchProducts() got error %v", err)
+	}
+
+	// what the service does to the page it returns
+	products[0].Stock = 7
+	products[0].Components = []models.BundleComponent{{ComponentID: 2, Quantity: 1}}
+	products[0].Availability = models.AvailabilityInStock
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		result, err := cache.GetSearchResult(ctx, 0, searchCacheKey(searchParam))
+		if err != nil {
+			t.Fatalf("GetSearchResult() got error %v", err)
+		}
+		if result.Products != nil {
+			cached := result.Products[0]
+			if cached.Stock != 0 || cached.Components != nil || cached.Availability != "" {
+				t.Errorf("cached page holds the resolved product %+v, want it as loaded", cached)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("search page was not cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
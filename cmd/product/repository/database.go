@@ -2,15 +2,19 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"product_commerce/models"
+	"strconv"
+	"time"
 )
 
 func (r *ProductRepository) FindByProductId(ctx context.Context, productId int64) (*models.Product, error) {
 	var product models.Product
-	err := r.Database.WithContext(ctx).Table("product").Where("id = ?", productId).Last(&product).Error
+	err := r.reader(ctx, productCacheKey(productId)).Table("product").Where("id = ?", productId).Last(&product).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &models.Product{}, nil
@@ -23,7 +27,7 @@ func (r *ProductRepository) FindByProductId(ctx context.Context, productId int64
 
 func (r *ProductRepository) FindProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
 	var prodCat models.ProductCategory
-	err := r.Database.WithContext(ctx).Table("product_category").Where("id = ?", productCatId).Last(&prodCat).Error
+	err := r.reader(ctx, productCatCacheKey(productCatId)).Table("product_category").Where("id = ?", productCatId).Last(&prodCat).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &models.ProductCategory{}, nil
@@ -34,66 +38,409 @@ func (r *ProductRepository) FindProductCatById(ctx context.Context, productCatId
 }
 
 func (r *ProductRepository) InsertNewProduct(ctx context.Context, product *models.Product) (int, error) {
-	err := r.Database.WithContext(ctx).Table("product").Create(product).Error
+	product.Version = 1
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("product").Create(product).Error
+		if err != nil {
+			return err
+		}
+
+		err = replaceBundleComponents(tx, product)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProduct, product.ID, models.AuditActionCreate, nil, product)
+	})
 	if err != nil {
 		return 0, err
 	}
 
+	r.markProductsWritten(ctx, product.ID)
 	return product.ID, nil
 }
 
 func (r *ProductRepository) InsertNewProductCat(ctx context.Context, productCat *models.ProductCategory) (int, error) {
-	err := r.Database.WithContext(ctx).Table("product_category").Create(productCat).Error
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("product_category").Create(productCat).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProductCat, productCat.ID, models.AuditActionCreate, nil, productCat)
+	})
 	if err != nil {
 		return 0, err
 	}
+
+	r.markProductCatWritten(ctx, productCat.ID)
 	return productCat.ID, nil
 }
 
+// InsertNewProducts creates many products in one transaction, e.g. when seeding the catalog, and sets their ids.
+func (r *ProductRepository) InsertNewProducts(ctx context.Context, products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range products {
+			products[i].Version = 1
+		}
+		err := tx.Table("product").Create(&products).Error
+		if err != nil {
+			return err
+		}
+
+		var components []models.BundleComponent
+		entries := make([]*models.AuditEntry, 0, len(products))
+		for i := range products {
+			if products[i].IsBundle {
+				for _, component := range products[i].Components {
+					component.BundleID = products[i].ID
+					components = append(components, component)
+				}
+			}
+
+			entry, err := newAuditEntry(ctx, models.AuditEntityProduct, products[i].ID, models.AuditActionCreate, nil, &products[i])
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+
+		if len(components) > 0 {
+			err = tx.Table("product_bundle_component").Create(&components).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Table("audit_log").Create(entries).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	productIds := make([]int, len(products))
+	for i := range products {
+		productIds[i] = products[i].ID
+	}
+	r.markProductsWritten(ctx, productIds...)
+	return nil
+}
+
+// InsertNewProductCats creates many categories in one transaction and sets their ids.
+func (r *ProductRepository) InsertNewProductCats(ctx context.Context, productCats []models.ProductCategory) error {
+	if len(productCats) == 0 {
+		return nil
+	}
+
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("product_category").Create(&productCats).Error
+		if err != nil {
+			return err
+		}
+
+		entries := make([]*models.AuditEntry, 0, len(productCats))
+		for i := range productCats {
+			entry, err := newAuditEntry(ctx, models.AuditEntityProductCat, productCats[i].ID, models.AuditActionCreate, nil, &productCats[i])
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return tx.Table("audit_log").Create(entries).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, productCat := range productCats {
+		r.markProductCatWritten(ctx, productCat.ID)
+	}
+	return nil
+}
+
+// UpdateProduct overwrites the product only when it is still at the version the caller read, and moves it to
+// the next version. It returns ErrVersionConflict when someone else wrote the product in the meantime.
 func (r *ProductRepository) UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
-	err := r.Database.WithContext(ctx).Table("product").Save(&product).Error
+	expectedVersion := product.Version
+	product.Version = expectedVersion + 1
+
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := findProductForUpdate(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return models.ErrProductNotFound
+		}
+
+		// stock only changes through stock adjustments, an edit keeps the stock of the locked row
+		product.Stock = before.Stock
+		result := tx.Table("product").
+			Where("id = ? AND version = ?", product.ID, expectedVersion).
+			Select("*").Omit("id", "stock").
+			Updates(product)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: product %d is no longer at version %d", models.ErrVersionConflict, product.ID, expectedVersion)
+		}
+
+		err = replaceBundleComponents(tx, product)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProduct, product.ID, models.AuditActionUpdate, before, product)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	r.markProductsWritten(ctx, product.ID)
 	return product, nil
 }
 
 func (r *ProductRepository) UpdateProductCat(ctx context.Context, product *models.ProductCategory) (*models.ProductCategory, error) {
-	err := r.Database.WithContext(ctx).Table("product_category").Save(&product).Error
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := findProductCatForUpdate(tx, product.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Table("product_category").Save(&product).Error
+		if err != nil {
+			return err
+		}
+
+		// Save creates a category that does not exist yet
+		action := models.AuditActionUpdate
+		if before == nil {
+			action = models.AuditActionCreate
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProductCat, product.ID, action, before, product)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	r.markProductCatWritten(ctx, product.ID)
 	return product, nil
 }
 
+// DeleteProduct deletes a product together with its own bundle components. It returns ErrProductInUse while the
+// product is a component of a bundle.
 func (r *ProductRepository) DeleteProduct(ctx context.Context, id int) error {
-	err := r.Database.WithContext(ctx).Table("product").Delete(&models.Product{}, id).Error
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := findProductForUpdate(tx, id)
+		if err != nil || before == nil {
+			return err
+		}
+
+		err = tx.Table("product_bundle_component").Where("bundle_id = ?", id).Delete(&models.BundleComponent{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Table("product").Delete(&models.Product{}, id).Error
+		if isForeignKeyViolation(tx, err) {
+			return fmt.Errorf("%w: product %d", models.ErrProductInUse, id)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProduct, id, models.AuditActionDelete, before, nil)
+	})
 	if err != nil {
 		return err
 	}
+
+	r.markProductsWritten(ctx, id)
 	return nil
 }
 
+// DeleteProductCat deletes a category. It returns ErrCategoryInUse while products still belong to the category.
 func (r *ProductRepository) DeleteProductCat(ctx context.Context, id int) error {
-	err := r.Database.WithContext(ctx).Table("product_category").Delete(&models.ProductCategory{}, id).Error
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := findProductCatForUpdate(tx, id)
+		if err != nil || before == nil {
+			return err
+		}
+
+		err = tx.Table("product_category").Delete(&models.ProductCategory{}, id).Error
+		if isForeignKeyViolation(tx, err) {
+			return fmt.Errorf("%w: category %d", models.ErrCategoryInUse, id)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditEntityProductCat, id, models.AuditActionDelete, before, nil)
+	})
 	if err != nil {
 		return err
 	}
+
+	r.markProductCatWritten(ctx, id)
 	return nil
 }
 
+// isForeignKeyViolation reports whether err is the driver error of a violated foreign key.
+func isForeignKeyViolation(db *gorm.DB, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	translator, ok := db.Dialector.(gorm.ErrorTranslator)
+	return ok && errors.Is(translator.Translate(err), gorm.ErrForeignKeyViolated)
+}
+
+// ReassignProductsCategory moves every product of one category to another and returns the ids of the moved
+// products.
+func (r *ProductRepository) ReassignProductsCategory(ctx context.Context, fromCatId int, toCatId int) ([]int, error) {
+	var productIds []int
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		var products []models.Product
+		err := tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("category_id = ?", fromCatId).Order("id").Find(&products).Error
+		if err != nil || len(products) == 0 {
+			return err
+		}
+
+		for _, product := range products {
+			productIds = append(productIds, product.ID)
+		}
+		err = tx.Table("product").Where("id IN ?", productIds).Updates(map[string]interface{}{
+			"category_id": toCatId,
+			"version":     gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range products {
+			before := products[i]
+			products[i].CategoryID = toCatId
+			products[i].Version++
+			err = recordAudit(ctx, tx, models.AuditEntityProduct, before.ID, models.AuditActionUpdate, &before, &products[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.markProductsWritten(ctx, productIds...)
+	return productIds, nil
+}
+
+const (
+	searchProductColumns = "product.id, product.name, product.description, product.price, product.cost, product.stock, product.category_id, " +
+		"product.inventory_policy, product.backorder_limit, product.preorder_available_at, product.is_bundle, product.is_lot_tracked, " +
+		"product.version, product_category.name as category"
+
+	// must match the configuration of the search_vector column
+	fullTextSearchConfig = "english"
+	// names are short enough to be returned whole, descriptions are cut down to the fragments that match
+	searchNameHeadlineOptions = searchHighlightOptions + ", HighlightAll=true"
+	searchHeadlineOptions     = searchHighlightOptions + ", MaxFragments=2, MaxWords=20, MinWords=5"
+	searchHighlightOptions    = `StartSel="` + models.SearchHighlightStart + `", StopSel="` + models.SearchHighlightStop + `"`
+)
+
+// SearchProducts filters, sorts and pages the catalog. A fuzzy search matches the name by trigram similarity
+// above the configured threshold, which is set for its own transaction only.
 func (r *ProductRepository) SearchProducts(ctx context.Context, searchParam *models.SearchProductParameter) ([]models.Product, int64, error) {
+	db := r.reader(ctx)
+	if !searchParam.Fuzzy || searchParam.Name == "" || !hasTextSearch(db) {
+		return searchProducts(db, searchParam)
+	}
+
+	var (
+		products   []models.Product
+		totalCount int64
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := setSimilarityThreshold(tx, r.FuzzyThreshold)
+		if err != nil {
+			return err
+		}
+
+		products, totalCount, err = searchProducts(tx, searchParam)
+		return err
+	})
+	if err != nil {
+		return []models.Product{}, 0, err
+	}
+
+	return products, totalCount, nil
+}
+
+// SuggestProductName returns the product name most similar to term, or an empty string when no name is similar
+// enough.
+func (r *ProductRepository) SuggestProductName(ctx context.Context, term string) (string, error) {
+	db := r.reader(ctx)
+	if !hasTextSearch(db) {
+		return "", nil
+	}
+
+	var names []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := setSimilarityThreshold(tx, r.FuzzyThreshold)
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("product").
+			Where("product.name % ?", term).
+			Order(clause.OrderBy{Expression: clause.Expr{SQL: "similarity(product.name, ?) DESC, product.id", Vars: []interface{}{term}}}).
+			Limit(1).
+			Pluck("product.name", &names).Error
+	})
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+
+	return names[0], nil
+}
+
+func setSimilarityThreshold(tx *gorm.DB, threshold float64) error {
+	return tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', ?, true)", strconv.FormatFloat(threshold, 'f', -1, 64)).Error
+}
+
+// hasTextSearch reports whether db supports full-text and trigram search. Without it, e.g. on SQLite, text
+// queries fall back to unranked substring matches.
+func hasTextSearch(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+func searchProducts(db *gorm.DB, searchParam *models.SearchProductParameter) ([]models.Product, int64, error) {
 	var products []models.Product
 	var totalCount int64
+	textSearch := hasTextSearch(db)
 
-	query := r.Database.WithContext(ctx).Table("product").
-		Select("product.id, product.name, product.description, product.price, product.stock, product.category_id, product_category.name as category").
+	query := db.Table("product").
+		Select(searchProductColumns).
 		Joins("JOIN product_category ON product.category_id = product_category.id")
 
+	if searchParam.Query != "" {
+		if textSearch {
+			query = query.Where("product.search_vector @@ websearch_to_tsquery('"+fullTextSearchConfig+"', ?)", searchParam.Query)
+		} else {
+			query = query.Where("product.name LIKE @pattern OR product.description LIKE @pattern",
+				sql.Named("pattern", "%"+searchParam.Query+"%"))
+		}
+	}
+
 	if searchParam.Name != "" {
-		query = query.Where("product.name LIKE ?", "%"+searchParam.Name+"%")
+		if searchParam.Fuzzy && textSearch {
+			// the % operator can use the trigram index, unlike a comparison of similarity()
+			query = query.Where("product.name % ?", searchParam.Name)
+		} else {
+			query = query.Where("product.name LIKE ?", "%"+searchParam.Name+"%")
+		}
 	}
 
 	if searchParam.Category != "" {
@@ -112,16 +459,26 @@ func (r *ProductRepository) SearchProducts(ctx context.Context, searchParam *mod
 	query.Model(&models.Product{}).Count(&totalCount)
 
 	//default order by
-	if searchParam.SortBy == "" {
-		searchParam.SortBy = "product.name"
-
-	}
+	searchParam.Normalize()
 
-	if searchParam.OrderBy == "" || (searchParam.OrderBy != "asc" && searchParam.OrderBy != "desc") {
-		searchParam.OrderBy = "asc"
+	if searchParam.Query != "" && textSearch {
+		tsQuery := "websearch_to_tsquery('" + fullTextSearchConfig + "', @query)"
+		query = query.Select(searchProductColumns+
+			", ts_rank_cd(product.search_vector, "+tsQuery+") AS rank"+
+			", ts_headline('"+fullTextSearchConfig+"', product.name, "+tsQuery+", '"+searchNameHeadlineOptions+"') AS name_snippet"+
+			", ts_headline('"+fullTextSearchConfig+"', product.description, "+tsQuery+", '"+searchHeadlineOptions+"') AS snippet",
+			sql.Named("query", searchParam.Query))
+	} else if searchParam.Fuzzy && searchParam.Name != "" && textSearch {
+		query = query.Select(searchProductColumns+", similarity(product.name, ?) AS rank", searchParam.Name)
+	} else if searchParam.SortBy == models.SearchRankColumn {
+		// substring matches are all equally relevant
+		query = query.Select(searchProductColumns + ", 0 AS rank")
 	}
 
 	query = query.Order(fmt.Sprintf("%s %s", searchParam.SortBy, searchParam.OrderBy))
+	if searchParam.SortBy == models.SearchRankColumn {
+		query = query.Order("product.id")
+	}
 
 	//pagination
 
@@ -135,3 +492,591 @@ func (r *ProductRepository) SearchProducts(ctx context.Context, searchParam *mod
 
 	return products, totalCount, nil
 }
+
+func (r *ProductRepository) AdjustProductStock(ctx context.Context, productId int, quantity int, reason string) (*models.Product, error) {
+	var product models.Product
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", productId).Take(&product).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return models.ErrProductNotFound
+			}
+			return err
+		}
+
+		if product.IsBundle {
+			return adjustBundleStock(ctx, tx, &product, quantity, reason)
+		}
+
+		newStock := product.Stock + quantity
+		if quantity < 0 && !product.AllowsStock(newStock) {
+			return models.ErrInsufficientStock
+		}
+
+		return applyStockAdjustment(ctx, tx, &product, quantity, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.markProductsWritten(ctx, productId)
+	for _, component := range product.Components {
+		r.markProductsWritten(ctx, component.ComponentID)
+	}
+	return &product, nil
+}
+
+// ReconcileStock compares counted quantities against stored stock. When apply is set the differences are
+// written as adjustments in a single transaction; otherwise the stored stock is left untouched.
+func (r *ProductRepository) ReconcileStock(ctx context.Context, counts []models.StockCount, apply bool) (*models.StockReconciliation, error) {
+	result := &models.StockReconciliation{
+		DryRun:            !apply,
+		Items:             []models.StockCountDiff{},
+		UnknownProductIDs: []int{},
+	}
+
+	productIds := make([]int, 0, len(counts))
+	for _, count := range counts {
+		productIds = append(productIds, count.ProductID)
+	}
+
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Table("product").Where("id IN ?", productIds).Order("id")
+		if apply {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+
+		var products []models.Product
+		err := query.Find(&products).Error
+		if err != nil {
+			return err
+		}
+
+		productById := make(map[int]*models.Product, len(products))
+		for i := range products {
+			productById[products[i].ID] = &products[i]
+		}
+
+		for _, count := range counts {
+			product, ok := productById[count.ProductID]
+			if !ok {
+				result.UnknownProductIDs = append(result.UnknownProductIDs, count.ProductID)
+				continue
+			}
+
+			result.Items = append(result.Items, models.StockCountDiff{
+				ProductID:       product.ID,
+				CurrentStock:    product.Stock,
+				CountedQuantity: count.CountedQuantity,
+				Difference:      count.CountedQuantity - product.Stock,
+			})
+		}
+
+		if !apply {
+			return nil
+		}
+
+		if len(result.UnknownProductIDs) > 0 {
+			return fmt.Errorf("%w: %v", models.ErrProductNotFound, result.UnknownProductIDs)
+		}
+
+		for _, item := range result.Items {
+			if item.Difference == 0 {
+				continue
+			}
+
+			err = applyStockAdjustment(ctx, tx, productById[item.ProductID], item.Difference, models.StockReasonCycleCount)
+			if err != nil {
+				return err
+			}
+			result.AdjustedCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if apply {
+		for _, item := range result.Items {
+			r.markProductsWritten(ctx, item.ProductID)
+		}
+	}
+	return result, nil
+}
+
+// adjustBundleStock moves the stock of every component of the bundle instead of the bundle itself, so that a
+// bundle can never be sold when one of its components cannot.
+func adjustBundleStock(ctx context.Context, tx *gorm.DB, bundle *models.Product, quantity int, reason string) error {
+	var components []models.BundleComponent
+	err := tx.Table("product_bundle_component").Where("bundle_id = ?", bundle.ID).Order("component_id").Find(&components).Error
+	if err != nil {
+		return err
+	}
+
+	if len(components) == 0 {
+		return fmt.Errorf("%w: bundle %d has no components", models.ErrInvalidBundle, bundle.ID)
+	}
+
+	for i := range components {
+		var component models.Product
+		err = tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", components[i].ComponentID).Take(&component).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: component %d of bundle %d", models.ErrProductNotFound, components[i].ComponentID, bundle.ID)
+			}
+			return err
+		}
+
+		componentQuantity := quantity * components[i].Quantity
+		if componentQuantity < 0 && !component.AllowsStock(component.Stock+componentQuantity) {
+			return fmt.Errorf("%w: component %d of bundle %d", models.ErrInsufficientStock, component.ID, bundle.ID)
+		}
+
+		err = applyStockAdjustment(ctx, tx, &component, componentQuantity, fmt.Sprintf("%s (bundle %d)", reason, bundle.ID))
+		if err != nil {
+			return err
+		}
+		components[i].Product = &component
+	}
+
+	bundle.Components = components
+	bundle.ResolveBundleStock()
+	return nil
+}
+
+// findProductForUpdate locks a product together with its bundle components, or returns nil when it does not
+// exist.
+func findProductForUpdate(tx *gorm.DB, productId int) (*models.Product, error) {
+	var product models.Product
+	err := tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", productId).Take(&product).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if product.IsBundle {
+		err = tx.Table("product_bundle_component").Where("bundle_id = ?", productId).Order("component_id").Find(&product.Components).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &product, nil
+}
+
+// findProductCatForUpdate locks a category, or returns nil when it does not exist.
+func findProductCatForUpdate(tx *gorm.DB, productCatId int) (*models.ProductCategory, error) {
+	var productCat models.ProductCategory
+	err := tx.Table("product_category").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", productCatId).Take(&productCat).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &productCat, nil
+}
+
+func replaceBundleComponents(tx *gorm.DB, product *models.Product) error {
+	err := tx.Table("product_bundle_component").Where("bundle_id = ?", product.ID).Delete(&models.BundleComponent{}).Error
+	if err != nil {
+		return err
+	}
+
+	if !product.IsBundle || len(product.Components) == 0 {
+		return nil
+	}
+
+	for i := range product.Components {
+		product.Components[i].BundleID = product.ID
+	}
+	return tx.Table("product_bundle_component").Create(&product.Components).Error
+}
+
+// applyStockAdjustment moves the stock of a locked product and records the adjustment. Lot tracked products
+// also move the quantity of their lots.
+func applyStockAdjustment(ctx context.Context, tx *gorm.DB, product *models.Product, quantity int, reason string) error {
+	if product.IsLotTracked {
+		err := allocateLots(tx, product.ID, quantity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return recordStockAdjustment(ctx, tx, product, quantity, reason)
+}
+
+// allocateLots takes stock out of the lots of a product first-expiry-first-out and never from expired lots.
+// Stock coming back goes to the unexpired lot that expires first, i.e. the one it would be allocated from.
+func allocateLots(tx *gorm.DB, productId int, quantity int) error {
+	query := tx.Table("product_lot").Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND expires_at > ?", productId, time.Now()).
+		Order("expires_at, id")
+	if quantity < 0 {
+		query = query.Where("quantity > 0")
+	}
+
+	var lots []models.ProductLot
+	err := query.Find(&lots).Error
+	if err != nil {
+		return err
+	}
+
+	if quantity > 0 {
+		if len(lots) == 0 {
+			return fmt.Errorf("%w: product %d has no unexpired lot to return stock to", models.ErrLotNotFound, productId)
+		}
+		return tx.Table("product_lot").Where("id = ?", lots[0].ID).Update("quantity", lots[0].Quantity+quantity).Error
+	}
+
+	remaining := -quantity
+	for _, lot := range lots {
+		if remaining == 0 {
+			break
+		}
+
+		allocated := min(lot.Quantity, remaining)
+		err = tx.Table("product_lot").Where("id = ?", lot.ID).Update("quantity", lot.Quantity-allocated).Error
+		if err != nil {
+			return err
+		}
+		remaining -= allocated
+	}
+
+	if remaining > 0 {
+		return fmt.Errorf("%w: product %d is short of %d unexpired units", models.ErrInsufficientStock, productId, remaining)
+	}
+	return nil
+}
+
+// recordStockAdjustment writes the new stock of a locked product together with the adjustment and its audit entry.
+func recordStockAdjustment(ctx context.Context, tx *gorm.DB, product *models.Product, quantity int, reason string) error {
+	before := *product
+	newStock := product.Stock + quantity
+	err := tx.Table("product").Where("id = ?", product.ID).Updates(map[string]interface{}{
+		"stock":   newStock,
+		"version": gorm.Expr("version + 1"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	product.Stock = newStock
+	product.Version++
+
+	err = tx.Table("stock_adjustment").Create(&models.StockAdjustment{
+		ProductID: product.ID,
+		Quantity:  quantity,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, models.AuditEntityProduct, product.ID, models.AuditActionUpdate, &before, product)
+}
+
+// CaptureInventorySnapshot stores the current stock and unit cost of every product for the given date. Capturing
+// the same date again overwrites that day's snapshot.
+func (r *ProductRepository) CaptureInventorySnapshot(ctx context.Context, snapshotDate time.Time) (int64, error) {
+	result := r.db(ctx).Exec(`
+		INSERT INTO inventory_snapshot (snapshot_date, product_id, category_id, stock, unit_cost)
+		SELECT ?, product.id, product.category_id, product.stock, product.cost FROM product WHERE NOT product.is_bundle
+		ON CONFLICT (snapshot_date, product_id) DO UPDATE
+		SET category_id = EXCLUDED.category_id, stock = EXCLUDED.stock, unit_cost = EXCLUDED.unit_cost`,
+		snapshotDate.Format(time.DateOnly))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// FindLatestSnapshotDate returns the date of the latest snapshot taken on or before the given date, nil when
+// there is none. A day whose capture failed is served by the snapshot before it.
+func (r *ProductRepository) FindLatestSnapshotDate(ctx context.Context, onOrBefore time.Time) (*time.Time, error) {
+	var snapshotDates []time.Time
+	err := r.db(ctx).Table("inventory_snapshot").
+		Where("snapshot_date <= ?", onOrBefore.Format(time.DateOnly)).
+		Order("snapshot_date DESC").
+		Limit(1).
+		Pluck("snapshot_date", &snapshotDates).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshotDates) == 0 {
+		return nil, nil
+	}
+
+	return &snapshotDates[0], nil
+}
+
+func (r *ProductRepository) FindInventorySnapshot(ctx context.Context, snapshotDate time.Time) ([]models.InventorySnapshot, error) {
+	var snapshots []models.InventorySnapshot
+	err := r.db(ctx).Table("inventory_snapshot").
+		Select("inventory_snapshot.snapshot_date, inventory_snapshot.product_id, COALESCE(product.name, '') as product_name, "+
+			"inventory_snapshot.category_id, inventory_snapshot.stock, inventory_snapshot.unit_cost").
+		Joins("LEFT JOIN product ON product.id = inventory_snapshot.product_id").
+		Where("inventory_snapshot.snapshot_date = ?", snapshotDate.Format(time.DateOnly)).
+		Order("inventory_snapshot.product_id").
+		Scan(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// GetInventoryValuation sums the stock value per category from the snapshot of the given date, or from the live
+// product table when no date is given. Backordered (negative) stock carries no value.
+func (r *ProductRepository) GetInventoryValuation(ctx context.Context, snapshotDate *time.Time) ([]models.CategoryValuation, error) {
+	var valuations []models.CategoryValuation
+
+	source, stockColumn, costColumn := "product", "product.stock", "product.cost"
+	if snapshotDate != nil {
+		source, stockColumn, costColumn = "inventory_snapshot", "inventory_snapshot.stock", "inventory_snapshot.unit_cost"
+	}
+
+	query := r.db(ctx).Table(source).
+		Select(fmt.Sprintf("%[1]s.category_id, COALESCE(product_category.name, '') as category_name, SUM(%[2]s) as total_stock, "+
+			"SUM(CASE WHEN %[2]s > 0 THEN %[2]s * %[3]s ELSE 0 END) as total_value", source, stockColumn, costColumn)).
+		Joins(fmt.Sprintf("LEFT JOIN product_category ON product_category.id = %s.category_id", source)).
+		Group(fmt.Sprintf("%s.category_id, product_category.name", source)).
+		Order(fmt.Sprintf("%s.category_id", source))
+
+	if snapshotDate != nil {
+		query = query.Where("inventory_snapshot.snapshot_date = ?", snapshotDate.Format(time.DateOnly))
+	} else {
+		// bundle stock is derived from its components and is already valued there
+		query = query.Where("NOT product.is_bundle")
+	}
+
+	err := query.Scan(&valuations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return valuations, nil
+}
+
+func (r *ProductRepository) FindProductsByIds(ctx context.Context, productIds []int) ([]models.Product, error) {
+	var products []models.Product
+	err := r.db(ctx).Table("product").Where("id IN ?", productIds).Order("id").Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// IsBundleComponent reports whether the product is a component of any bundle.
+func (r *ProductRepository) IsBundleComponent(ctx context.Context, productId int) (bool, error) {
+	var bundleIds []int
+	err := r.db(ctx).Table("product_bundle_component").
+		Where("component_id = ?", productId).
+		Limit(1).
+		Pluck("bundle_id", &bundleIds).Error
+	if err != nil {
+		return false, err
+	}
+
+	return len(bundleIds) > 0, nil
+}
+
+// FindBundleComponents loads the components of the given bundles, keyed by bundle id, together with the
+// current state of each component product.
+func (r *ProductRepository) FindBundleComponents(ctx context.Context, bundleIds []int) (map[int][]models.BundleComponent, error) {
+	var components []models.BundleComponent
+	err := r.db(ctx).Table("product_bundle_component").
+		Where("bundle_id IN ?", bundleIds).
+		Order("bundle_id, component_id").
+		Find(&components).Error
+	if err != nil {
+		return nil, err
+	}
+
+	componentIds := make([]int, 0, len(components))
+	for _, component := range components {
+		componentIds = append(componentIds, component.ComponentID)
+	}
+
+	products, err := r.FindProductsByIds(ctx, componentIds)
+	if err != nil {
+		return nil, err
+	}
+
+	productById := make(map[int]*models.Product, len(products))
+	for i := range products {
+		productById[products[i].ID] = &products[i]
+	}
+
+	componentsByBundle := make(map[int][]models.BundleComponent, len(bundleIds))
+	for _, component := range components {
+		component.Product = productById[component.ComponentID]
+		componentsByBundle[component.BundleID] = append(componentsByBundle[component.BundleID], component)
+	}
+	return componentsByBundle, nil
+}
+
+// ReceiveLot stores a new lot of a lot tracked product and adds its quantity to the product stock.
+func (r *ProductRepository) ReceiveLot(ctx context.Context, lot *models.ProductLot) (*models.Product, error) {
+	var product models.Product
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", lot.ProductID).Take(&product).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return models.ErrProductNotFound
+			}
+			return err
+		}
+
+		if !product.IsLotTracked {
+			return models.ErrLotNotTracked
+		}
+
+		lot.CreatedAt = time.Now()
+		err = tx.Table("product_lot").Create(lot).Error
+		if err != nil {
+			return err
+		}
+
+		return recordStockAdjustment(ctx, tx, &product, lot.Quantity, fmt.Sprintf("lot %s received", lot.LotNumber))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.markProductsWritten(ctx, product.ID)
+	return &product, nil
+}
+
+// WriteOffLot removes whatever is left of a lot, e.g. once it has expired, from the lot and the product stock.
+func (r *ProductRepository) WriteOffLot(ctx context.Context, lotId int) (*models.Product, error) {
+	var product models.Product
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		var lot models.ProductLot
+		err := tx.Table("product_lot").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", lotId).Take(&lot).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return models.ErrLotNotFound
+			}
+			return err
+		}
+
+		err = tx.Table("product").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", lot.ProductID).Take(&product).Error
+		if err != nil {
+			return err
+		}
+
+		if lot.Quantity == 0 {
+			return nil
+		}
+
+		err = tx.Table("product_lot").Where("id = ?", lot.ID).Update("quantity", 0).Error
+		if err != nil {
+			return err
+		}
+
+		return recordStockAdjustment(ctx, tx, &product, -lot.Quantity, fmt.Sprintf("lot %s written off", lot.LotNumber))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.markProductsWritten(ctx, product.ID)
+	return &product, nil
+}
+
+func (r *ProductRepository) FindExpiringLots(ctx context.Context, expiresBefore time.Time) ([]models.ExpiringLot, error) {
+	var lots []models.ExpiringLot
+	err := r.db(ctx).Table("product_lot").
+		Select("product_lot.*, product.name as product_name").
+		Joins("JOIN product ON product.id = product_lot.product_id").
+		Where("product_lot.quantity > 0 AND product_lot.expires_at <= ?", expiresBefore).
+		Order("product_lot.expires_at, product_lot.id").
+		Scan(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+func (r *ProductRepository) FindAllProductCats(ctx context.Context) ([]models.ProductCategory, error) {
+	var productCats []models.ProductCategory
+	err := r.db(ctx).Table("product_category").Order("id").Find(&productCats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return productCats, nil
+}
+
+// FindTopProductIds ranks products by one of the TopProductsBy criteria and returns the ids of the first ones.
+func (r *ProductRepository) FindTopProductIds(ctx context.Context, criteria string, limit int) ([]int, error) {
+	var productIds []int
+
+	query := r.db(ctx).Table("product").Select("product.id").Limit(limit)
+	switch criteria {
+	case TopProductsByReserved:
+		query = query.
+			Joins("JOIN stock_adjustment ON stock_adjustment.product_id = product.id").
+			Where("stock_adjustment.reason = ? AND stock_adjustment.created_at >= ?",
+				models.StockReasonReservation, time.Now().Add(-topProductsReservedWindow)).
+			Group("product.id").
+			Order("SUM(-stock_adjustment.quantity) DESC")
+	case TopProductsByNewest:
+		query = query.Order("product.id DESC")
+	case TopProductsByStock:
+		query = query.Order("product.stock DESC, product.id")
+	case TopProductsByPrice:
+		query = query.Order("product.price DESC, product.id")
+	default:
+		return nil, fmt.Errorf("unknown top products criteria %q", criteria)
+	}
+
+	err := query.Pluck("product.id", &productIds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return productIds, nil
+}
+
+func (r *ProductRepository) EnqueueCacheInvalidations(ctx context.Context, invalidations []models.PendingCacheInvalidation) error {
+	if len(invalidations) == 0 {
+		return nil
+	}
+
+	return r.db(ctx).Table("cache_invalidation_queue").Create(&invalidations).Error
+}
+
+// FindDueCacheInvalidations returns the oldest queued invalidations whose next attempt is not after now.
+func (r *ProductRepository) FindDueCacheInvalidations(ctx context.Context, now time.Time, limit int) ([]models.PendingCacheInvalidation, error) {
+	var invalidations []models.PendingCacheInvalidation
+	err := r.db(ctx).Table("cache_invalidation_queue").
+		Where("next_attempt_at <= ?", now).
+		Order("id").
+		Limit(limit).
+		Find(&invalidations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return invalidations, nil
+}
+
+func (r *ProductRepository) DeleteCacheInvalidation(ctx context.Context, id int64) error {
+	return r.db(ctx).Table("cache_invalidation_queue").Delete(&models.PendingCacheInvalidation{}, id).Error
+}
+
+func (r *ProductRepository) RescheduleCacheInvalidation(ctx context.Context, invalidation *models.PendingCacheInvalidation) error {
+	return r.db(ctx).Table("cache_invalidation_queue").
+		Where("id = ?", invalidation.ID).
+		Updates(map[string]interface{}{
+			"attempts":        invalidation.Attempts,
+			"last_error":      invalidation.LastError,
+			"next_attempt_at": invalidation.NextAttemptAt,
+		}).Error
+}
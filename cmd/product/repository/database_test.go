@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"product_commerce/infra/migration"
+	"product_commerce/models"
+	"testing"
+	"time"
+)
+
+// newTestDatabase opens a private in-memory SQLite database with every migration applied.
+func newTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open() got error %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("db.DB() got error %v", err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	migrator, err := migration.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("migration.NewMigrator() got error %v", err)
+	}
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("migrator.Up() got error %v", err)
+	}
+	return db
+}
+
+// newTestLots creates a lot-tracked product with a lot per given quantity and expiry, and returns the lot ids.
+func newTestLots(t *testing.T, db *gorm.DB, lots map[string]struct {
+	quantity  int
+	expiresIn time.Duration
+}) (int, map[string]int) {
+	t.Helper()
+
+	err := db.Table("product_category").Create(&models.ProductCategory{Name: "Food"}).Error
+	if err != nil {
+		t.Fatalf("creating category got error %v", err)
+	}
+	product := &models.Product{Name: "Milk", CategoryID: 1, InventoryPolicy: models.InventoryPolicyDeny, IsLotTracked: true}
+	err = db.Table("product").Create(product).Error
+	if err != nil {
+		t.Fatalf("creating product got error %v", err)
+	}
+
+	lotIds := make(map[string]int, len(lots))
+	for lotNumber, lot := range lots {
+		productLot := &models.ProductLot{
+			ProductID: product.ID,
+			LotNumber: lotNumber,
+			Quantity:  lot.quantity,
+			ExpiresAt: time.Now().Add(lot.expiresIn),
+			CreatedAt: time.Now(),
+		}
+		err = db.Table("product_lot").Create(productLot).Error
+		if err != nil {
+			t.Fatalf("creating lot %s got error %v", lotNumber, err)
+		}
+		lotIds[lotNumber] = productLot.ID
+	}
+	return product.ID, lotIds
+}
+
+func lotQuantities(t *testing.T, db *gorm.DB, lotIds map[string]int) map[string]int {
+	t.Helper()
+
+	quantities := make(map[string]int, len(lotIds))
+	for lotNumber, lotId := range lotIds {
+		var lot models.ProductLot
+		err := db.Table("product_lot").Where("id = ?", lotId).Take(&lot).Error
+		if err != nil {
+			t.Fatalf("reading lot %s got error %v", lotNumber, err)
+		}
+		quantities[lotNumber] = lot.Quantity
+	}
+	return quantities
+}
+
+var testLots = map[string]struct {
+	quantity  int
+	expiresIn time.Duration
+}{
+	"expired": {quantity: 100, expiresIn: -time.Hour},
+	"early":   {quantity: 3, expiresIn: 24 * time.Hour},
+	"late":    {quantity: 5, expiresIn: 10 * 24 * time.Hour},
+}
+
+func TestAllocateLots(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int
+		wantErr  error
+		want     map[string]int
+	}{
+		{
+			name:     "takes from the lot expiring first",
+			quantity: -2,
+			want:     map[string]int{"expired": 100, "early": 1, "late": 5},
+		},
+		{
+			name:     "moves on to the next lot and skips expired ones",
+			quantity: -4,
+			want:     map[string]int{"expired": 100, "early": 0, "late": 4},
+		},
+		{
+			name:     "uses up every unexpired unit",
+			quantity: -8,
+			want:     map[string]int{"expired": 100, "early": 0, "late": 0},
+		},
+		{
+			name:     "never counts expired units as stock",
+			quantity: -9,
+			wantErr:  models.ErrInsufficientStock,
+		},
+		{
+			name:     "returns stock to the lot expiring first",
+			quantity: 2,
+			want:     map[string]int{"expired": 100, "early": 5, "late": 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			productId, lotIds := newTestLots(t, db, testLots)
+
+			err := allocateLots(db, productId, tt.quantity)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("allocateLots(%d) got error %v, want %v", tt.quantity, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocateLots(%d) got error %v", tt.quantity, err)
+			}
+
+			got := lotQuantities(t, db, lotIds)
+			for lotNumber, want := range tt.want {
+				if got[lotNumber] != want {
+					t.Errorf("lot %s holds %d after allocateLots(%d), want %d", lotNumber, got[lotNumber], tt.quantity, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateLotsReturnWithoutUnexpiredLot(t *testing.T) {
+	db := newTestDatabase(t)
+	productId, _ := newTestLots(t, db, map[string]struct {
+		quantity  int
+		expiresIn time.Duration
+	}{
+		"expired": {quantity: 1, expiresIn: -time.Hour},
+	})
+
+	err := allocateLots(db, productId, 1)
+	if !errors.Is(err, models.ErrLotNotFound) {
+		t.Errorf("allocateLots(1) got error %v, want %v", err, models.ErrLotNotFound)
+	}
+}
+
+func TestStockChangesAreAudited(t *testing.T) {
+	db := newTestDatabase(t)
+	repo := NewProductRepo(db, nil, 0)
+	productId, _ := newTestLots(t, db, map[string]struct {
+		quantity  int
+		expiresIn time.Duration
+	}{"A": {quantity: 0, expiresIn: time.Hour}})
+
+	ctx := context.WithValue(context.Background(), "actor", "alice")
+	ctx = context.WithValue(ctx, "actor_verified", false)
+
+	lot := &models.ProductLot{ProductID: productId, LotNumber: "B", Quantity: 5, ExpiresAt: time.Now().Add(2 * time.Hour)}
+	_, err := repo.ReceiveLot(ctx, lot)
+	if err != nil {
+		t.Fatalf("ReceiveLot() got error %v", err)
+	}
+	_, err = repo.AdjustProductStock(ctx, productId, -2, models.StockReasonReservation)
+	if err != nil {
+		t.Fatalf("AdjustProductStock() got error %v", err)
+	}
+	_, err = repo.ReconcileStock(ctx, []models.StockCount{{ProductID: productId, CountedQuantity: 4}}, true)
+	if err != nil {
+		t.Fatalf("ReconcileStock() got error %v", err)
+	}
+	// the counted unit goes back to lot A, which expires first, so three are left in B
+	_, err = repo.WriteOffLot(ctx, lot.ID)
+	if err != nil {
+		t.Fatalf("WriteOffLot() got error %v", err)
+	}
+
+	entries, _, err := repo.FindAuditEntries(ctx, &models.AuditFilter{Entity: models.AuditEntityProduct, EntityID: productId})
+	if err != nil {
+		t.Fatalf("FindAuditEntries() got error %v", err)
+	}
+
+	// newest first: written off, counted, sold, received
+	wantStock := [][2]float64{{4, 1}, {3, 4}, {5, 3}, {0, 5}}
+	if len(entries) != len(wantStock) {
+		t.Fatalf("got %d audit entries, want %d", len(entries), len(wantStock))
+	}
+	for i, entry := range entries {
+		if entry.Action != models.AuditActionUpdate || entry.Actor != "alice" || entry.ActorVerified {
+			t.Errorf("entry %d = %+v, want an update by the unverified actor alice", i, entry)
+		}
+		stock := entry.Changes["stock"]
+		if stock.Before != wantStock[i][0] || stock.After != wantStock[i][1] {
+			t.Errorf("entry %d changes stock from %v to %v, want %v to %v", i, stock.Before, stock.After, wantStock[i][0], wantStock[i][1])
+		}
+		if _, ok := entry.Changes["version"]; !ok {
+			t.Errorf("entry %d does not record the version change", i)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache[int](2, time.Minute)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	// reading a makes b the least recently used entry
+	if value, ok := cache.Get("a"); !ok || value != 1 {
+		t.Fatalf("Get(a) = %d, %t, want 1, true", value, ok)
+	}
+	cache.Set("c", 3)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Get(b) found an entry that should have been evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if value, ok := cache.Get(key); !ok || value != want {
+			t.Errorf("Get(%s) = %d, %t, want %d, true", key, value, ok, want)
+		}
+	}
+}
+
+func TestLRUCacheUpdateAndDelete(t *testing.T) {
+	cache := newLRUCache[string](2, time.Minute)
+	cache.Set("a", "old")
+	cache.Set("a", "new")
+	cache.Set("b", "b")
+
+	if value, ok := cache.Get("a"); !ok || value != "new" {
+		t.Errorf("Get(a) = %q, %t, want new, true", value, ok)
+	}
+
+	cache.Delete("a")
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Get(a) found an entry after Delete(a)")
+	}
+	if _, ok := cache.Get("b"); !ok {
+		t.Errorf("Get(b) missed an entry that was not deleted")
+	}
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	cache := newLRUCache[int](2, time.Millisecond)
+	cache.Set("a", 1)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Get(a) found an entry past its ttl")
+	}
+}
@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"product_commerce/config"
+	"product_commerce/models"
+	"sync"
+	"time"
+)
+
+// memoryCacheSweepInterval is how often expired entries are dropped, on the next write after it has passed.
+const memoryCacheSweepInterval = time.Minute
+
+// MemoryCache keeps the cache in the memory of a single instance, with the same ttls and semantics as
+// RedisCache. It needs no Redis, which suits demos and integration tests, but instances do not share it, so it
+// must not be used behind a load balancer.
+type MemoryCache struct {
+	TTL config.CacheTTLConfig
+
+	mu             sync.Mutex
+	entries        map[string]memoryCacheItem
+	catalogVersion int64
+	lastSweep      time.Time
+}
+
+type memoryCacheItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+func NewMemoryCache(ttl config.CacheTTLConfig) *MemoryCache {
+	if ttl.Product <= 0 {
+		ttl.Product = defaultProductCacheTTL
+	}
+	if ttl.ProductStale <= 0 {
+		ttl.ProductStale = defaultProductCacheStaleTTL
+	}
+	if ttl.ProductNotFound <= 0 {
+		ttl.ProductNotFound = defaultProductNotFoundTTL
+	}
+	if ttl.ProductCat <= 0 {
+		ttl.ProductCat = defaultProductCatCacheTTL
+	}
+	if ttl.Search <= 0 {
+		ttl.Search = defaultSearchCacheTTL
+	}
+
+	return &MemoryCache{
+		TTL:       ttl,
+		entries:   make(map[string]memoryCacheItem),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryCache) GetProductById(ctx context.Context, productId int64) (*models.Product, bool, error) {
+	entry, ok := m.get(productCacheKey(productId)).(cacheEntry[models.Product])
+	if !ok {
+		return &models.Product{}, false, nil
+	}
+	if entry.NotFound {
+		return &models.Product{}, false, ErrNotFoundCached
+	}
+
+	product := *entry.Value
+	stale := time.Now().Unix() >= entry.FreshUntil
+	return &product, stale, nil
+}
+
+// GetProducts returns the fresh products by id and the ids remembered as missing; any other id is a miss.
+func (m *MemoryCache) GetProducts(ctx context.Context, productIds []int) (map[int]models.Product, []int, error) {
+	products := make(map[int]models.Product, len(productIds))
+	var notFoundIds []int
+	now := time.Now().Unix()
+	for _, productId := range productIds {
+		entry, ok := m.get(productCacheKey(int64(productId))).(cacheEntry[models.Product])
+		switch {
+		case !ok:
+		case entry.NotFound:
+			notFoundIds = append(notFoundIds, productId)
+		case now < entry.FreshUntil:
+			products[productId] = *entry.Value
+		}
+	}
+
+	return products, notFoundIds, nil
+}
+
+func (m *MemoryCache) SetProductById(ctx context.Context, product *models.Product) error {
+	m.setProduct(product)
+	return nil
+}
+
+func (m *MemoryCache) SetProducts(ctx context.Context, products []models.Product) error {
+	for i := range products {
+		m.setProduct(&products[i])
+	}
+	return nil
+}
+
+// SetProductNotFound remembers for a short while that a product does not exist.
+func (m *MemoryCache) SetProductNotFound(ctx context.Context, productId int64) error {
+	m.set(productCacheKey(productId), cacheEntry[models.Product]{NotFound: true}, m.TTL.ProductNotFound)
+	return nil
+}
+
+func (m *MemoryCache) DeleteProductCache(ctx context.Context, productId int) error {
+	m.delete(productCacheKey(int64(productId)))
+	return nil
+}
+
+func (m *MemoryCache) GetProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
+	productCat, ok := m.get(productCatCacheKey(productCatId)).(models.ProductCategory)
+	if !ok {
+		return &models.ProductCategory{}, nil
+	}
+
+	return &productCat, nil
+}
+
+func (m *MemoryCache) SetProductCatById(ctx context.Context, productCat *models.ProductCategory) error {
+	m.set(productCatCacheKey(int64(productCat.ID)), *productCat, m.TTL.ProductCat)
+	return nil
+}
+
+func (m *MemoryCache) SetProductCats(ctx context.Context, productCats []models.ProductCategory) error {
+	for _, productCat := range productCats {
+		m.set(productCatCacheKey(int64(productCat.ID)), productCat, m.TTL.ProductCat)
+	}
+	return nil
+}
+
+func (m *MemoryCache) DeleteProductCatCache(ctx context.Context, productCatId int) error {
+	m.delete(productCatCacheKey(int64(productCatId)))
+	return nil
+}
+
+func (m *MemoryCache) GetSearchResult(ctx context.Context, catalogVersion int64, searchKey string) (*models.SearchProductResult, error) {
+	result, ok := m.get(fmt.Sprintf(cacheKeyProductSearch, catalogVersion, searchKey)).(models.SearchProductResult)
+	if !ok {
+		return &models.SearchProductResult{}, nil
+	}
+
+	// callers may change the products they get, which must not change the cached result
+	result.Products = append([]models.Product(nil), result.Products...)
+	return &result, nil
+}
+
+func (m *MemoryCache) SetSearchResult(ctx context.Context, catalogVersion int64, searchKey string, result *models.SearchProductResult) error {
+	cached := *result
+	cached.Products = append([]models.Product(nil), result.Products...)
+	m.set(fmt.Sprintf(cacheKeyProductSearch, catalogVersion, searchKey), cached, m.TTL.Search)
+	return nil
+}
+
+func (m *MemoryCache) GetCatalogVersion(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.catalogVersion, nil
+}
+
+func (m *MemoryCache) BumpCatalogVersion(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.catalogVersion++
+	return nil
+}
+
+// AcquireLock takes a short-lived lock within this instance. The returned token is needed to release it.
+func (m *MemoryCache) AcquireLock(ctx context.Context, name string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf(cacheKeyLock, name)
+	if item, ok := m.entries[key]; ok && time.Now().Before(item.expiresAt) {
+		return "", false, nil
+	}
+
+	token := uuid.NewString()
+	m.entries[key] = memoryCacheItem{value: token, expiresAt: time.Now().Add(cacheLockTTL)}
+	return token, true, nil
+}
+
+// ReleaseLock releases the lock only when it is still held with the given token.
+func (m *MemoryCache) ReleaseLock(ctx context.Context, name string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf(cacheKeyLock, name)
+	if item, ok := m.entries[key]; ok && item.value == token {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// setProduct caches a product with a jittered freshness, kept for the stale ttl after it.
+func (m *MemoryCache) setProduct(product *models.Product) {
+	freshFor := jitterTTL(m.TTL.Product)
+	value := *product
+	m.set(productCacheKey(int64(product.ID)), cacheEntry[models.Product]{
+		Value:      &value,
+		FreshUntil: time.Now().Add(freshFor).Unix(),
+	}, freshFor+m.TTL.ProductStale)
+}
+
+func (m *MemoryCache) get(key string) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(item.expiresAt) {
+		delete(m.entries, key)
+		return nil
+	}
+	return item.value
+}
+
+func (m *MemoryCache) set(key string, value interface{}, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.entries[key] = memoryCacheItem{value: value, expiresAt: now.Add(ttl)}
+
+	// entries that are never read again, e.g. searches of an older catalog version, are dropped here
+	if now.Sub(m.lastSweep) >= memoryCacheSweepInterval {
+		for key, item := range m.entries {
+			if !now.Before(item.expiresAt) {
+				delete(m.entries, key)
+			}
+		}
+		m.lastSweep = now
+	}
+}
+
+func (m *MemoryCache) delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
@@ -2,10 +2,13 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"product_commerce/config"
 	"product_commerce/infra/log"
 	"product_commerce/models"
 	"time"
@@ -13,35 +16,160 @@ import (
 
 var (
 	cacheKeyProductInfo    = "product:%d"
-	cacheKeyProductCatInfo = "product-info:%d"
+	cacheKeyProductCatInfo = "product-category:%d"
+	cacheKeyProductSearch  = "product-search:%d:%s"
+	cacheKeyCatalogVersion = "catalog-version"
+	cacheKeyLock           = "lock:%s"
+	cacheKeyPrefix         = "v%d:%s:"
 )
 
-func (r *ProductRepository) GetProductByIdFromRedis(ctx context.Context, productId int64) (*models.Product, error) {
-	var product models.Product
-	productKey := fmt.Sprintf(cacheKeyProductInfo, productId)
-	fmt.Println(productKey)
-	productStr, err := r.Redis.Get(ctx, productKey).Result()
+// cacheSchemaVersion is part of every Redis key. Bump it whenever a cached model changes in a way that older
+// entries can no longer be decoded into it: entries of the previous schema are then never read again and expire.
+const cacheSchemaVersion = 2
+
+// cacheCodecNames are the codecs an instance may write entries with. Invalidations reach the entries of every
+// codec and of the previous schema version too, so instances still running another config or the previous
+// release during a rolling deploy never keep serving a changed entity.
+var cacheCodecNames = []string{CodecJSON, CodecMsgpack}
+
+// Default ttls, used for every ttl left empty in the config.
+const (
+	defaultProductCacheTTL    = 10 * time.Minute
+	defaultProductCatCacheTTL = 10 * time.Minute
+	// how long an expired product may still be served while a single caller refreshes it
+	defaultProductCacheStaleTTL = time.Minute
+	// search results of an older catalog version are never read again and simply expire
+	defaultSearchCacheTTL = 10 * time.Minute
+	// missing products are remembered briefly so probes of unknown ids do not all reach the database
+	defaultProductNotFoundTTL = 30 * time.Second
+
+	cacheTTLJitter = 0.1
+	cacheLockTTL   = 3 * time.Second
+)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// ErrNotFoundCached is returned when the cache remembers that an entity does not exist.
+var ErrNotFoundCached = errors.New("cached as not found")
+
+// cacheEntry wraps a cached value with the moment it stops being fresh. The Redis key outlives that moment so
+// the stale value can still be served during a refresh. An entry without a value marks a missing entity.
+type cacheEntry[T any] struct {
+	Value      *T    `json:"value,omitempty"`
+	FreshUntil int64 `json:"fresh_until,omitempty"`
+	NotFound   bool  `json:"not_found,omitempty"`
+}
+
+type RedisCache struct {
+	Redis *redis.Client
+	Codec Codec
+	TTL   config.CacheTTLConfig
+
+	keyPrefix string
+}
+
+func NewRedisCache(redis *redis.Client, codec Codec, ttl config.CacheTTLConfig) *RedisCache {
+	if ttl.Product <= 0 {
+		ttl.Product = defaultProductCacheTTL
+	}
+	if ttl.ProductStale <= 0 {
+		ttl.ProductStale = defaultProductCacheStaleTTL
+	}
+	if ttl.ProductNotFound <= 0 {
+		ttl.ProductNotFound = defaultProductNotFoundTTL
+	}
+	if ttl.ProductCat <= 0 {
+		ttl.ProductCat = defaultProductCatCacheTTL
+	}
+	if ttl.Search <= 0 {
+		ttl.Search = defaultSearchCacheTTL
+	}
+
+	return &RedisCache{
+		Redis:     redis,
+		Codec:     codec,
+		TTL:       ttl,
+		keyPrefix: fmt.Sprintf(cacheKeyPrefix, cacheSchemaVersion, codec.Name()),
+	}
+}
+
+func (r *RedisCache) GetProductById(ctx context.Context, productId int64) (*models.Product, bool, error) {
+	productBytes, err := r.Redis.Get(ctx, r.key(productCacheKey(productId))).Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return &models.Product{}, nil
+			return &models.Product{}, false, nil
 		}
 
-		return nil, err
+		return nil, false, err
 	}
 
-	err = json.Unmarshal([]byte(productStr), &product)
+	var entry cacheEntry[models.Product]
+	err = r.Codec.Unmarshal(productBytes, &entry)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if entry.NotFound {
+		return &models.Product{}, false, ErrNotFoundCached
+	}
+	if entry.Value == nil {
+		return &models.Product{}, false, nil
+	}
+
+	stale := time.Now().Unix() >= entry.FreshUntil
+	return entry.Value, stale, nil
+}
+
+// GetProducts reads many products with a single MGET. It returns the fresh products by id and the ids remembered
+// as missing; any other id, including one whose product is stale, is a miss.
+func (r *RedisCache) GetProducts(ctx context.Context, productIds []int) (map[int]models.Product, []int, error) {
+	keys := make([]string, len(productIds))
+	for i, productId := range productIds {
+		keys[i] = r.key(productCacheKey(int64(productId)))
+	}
+
+	values, err := r.Redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	products := make(map[int]models.Product, len(productIds))
+	var notFoundIds []int
+	now := time.Now().Unix()
+	for i, value := range values {
+		entryStr, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var entry cacheEntry[models.Product]
+		err = r.Codec.Unmarshal([]byte(entryStr), &entry)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"product_id": productIds[i],
+			}).Errorf("failed to decode product on RedisCache GetProducts: %v", err)
+			continue
+		}
+
+		switch {
+		case entry.NotFound:
+			notFoundIds = append(notFoundIds, productIds[i])
+		case entry.Value != nil && now < entry.FreshUntil:
+			products[productIds[i]] = *entry.Value
+		}
 	}
 
-	return &product, nil
+	return products, notFoundIds, nil
 }
 
-func (r *ProductRepository) GetProductCatByIdFromRedis(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
+func (r *RedisCache) GetProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
 	var productCat models.ProductCategory
 
-	cacheKey := fmt.Sprintf(cacheKeyProductCatInfo, productCatId)
-	productCatStr, err := r.Redis.Get(ctx, cacheKey).Result()
+	cacheKey := r.key(productCatCacheKey(productCatId))
+	productCatBytes, err := r.Redis.Get(ctx, cacheKey).Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return &models.ProductCategory{}, nil
@@ -50,7 +178,7 @@ func (r *ProductRepository) GetProductCatByIdFromRedis(ctx context.Context, prod
 		return nil, err
 	}
 
-	err = json.Unmarshal([]byte(productCatStr), &productCat)
+	err = r.Codec.Unmarshal(productCatBytes, &productCat)
 	if err != nil {
 		return nil, err
 	}
@@ -58,46 +186,196 @@ func (r *ProductRepository) GetProductCatByIdFromRedis(ctx context.Context, prod
 	return &productCat, nil
 }
 
-func (r *ProductRepository) SetProductById(ctx context.Context, product *models.Product) error {
-	cacheKey := fmt.Sprintf(cacheKeyProductInfo, product.ID)
-	productJson, err := json.Marshal(product)
+func (r *RedisCache) SetProductById(ctx context.Context, product *models.Product) error {
+	entryBytes, ttl, err := r.productCacheEntry(product)
 	if err != nil {
-		log.Logger.Error("failed to marshal product on ProductRepository SetProductById")
+		log.Logger.Error("failed to marshal product on RedisCache SetProductById")
 		return err
 	}
 
-	err = r.Redis.SetEX(ctx, cacheKey, productJson, 10*time.Minute).Err()
+	err = r.Redis.SetEX(ctx, r.key(productCacheKey(int64(product.ID))), entryBytes, ttl).Err()
 	if err != nil {
-		log.Logger.Error("failed to set product cache on ProductRepository SetProductById")
+		log.Logger.Error("failed to set product cache on RedisCache SetProductById")
 		return err
 	}
 	return nil
 }
 
-func (r *ProductRepository) SetProductCatById(ctx context.Context, productCat *models.ProductCategory) error {
-	cacheKey := fmt.Sprintf(cacheKeyProductInfo, productCat.ID)
-	productCatJson, err := json.Marshal(productCat)
+// SetProducts caches many products in a single pipelined round trip.
+func (r *RedisCache) SetProducts(ctx context.Context, products []models.Product) error {
+	_, err := r.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range products {
+			entryBytes, ttl, err := r.productCacheEntry(&products[i])
+			if err != nil {
+				return err
+			}
+			pipe.SetEX(ctx, r.key(productCacheKey(int64(products[i].ID))), entryBytes, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// SetProductNotFound remembers for a short while that a product does not exist.
+func (r *RedisCache) SetProductNotFound(ctx context.Context, productId int64) error {
+	markerBytes, err := r.Codec.Marshal(cacheEntry[models.Product]{NotFound: true})
 	if err != nil {
 		return err
 	}
 
-	err = r.Redis.SetEX(ctx, cacheKey, productCatJson, 10*time.Minute).Err()
+	return r.Redis.SetEX(ctx, r.key(productCacheKey(productId)), markerBytes, r.TTL.ProductNotFound).Err()
+}
+
+func (r *RedisCache) SetProductCatById(ctx context.Context, productCat *models.ProductCategory) error {
+	cacheKey := r.key(productCatCacheKey(int64(productCat.ID)))
+	productCatBytes, err := r.Codec.Marshal(productCat)
+	if err != nil {
+		return err
+	}
+
+	err = r.Redis.SetEX(ctx, cacheKey, productCatBytes, r.TTL.ProductCat).Err()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ProductRepository) DeleteProductCache(ctx context.Context, productId int) error {
-	cacheKey := fmt.Sprintf(cacheKeyProductInfo, productId)
-	return r.DeleteRedisCacheKey(ctx, cacheKey)
+// SetProductCats caches many categories in a single pipelined round trip.
+func (r *RedisCache) SetProductCats(ctx context.Context, productCats []models.ProductCategory) error {
+	_, err := r.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, productCat := range productCats {
+			productCatBytes, err := r.Codec.Marshal(productCat)
+			if err != nil {
+				return err
+			}
+			pipe.SetEX(ctx, r.key(productCatCacheKey(int64(productCat.ID))), productCatBytes, r.TTL.ProductCat)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisCache) GetSearchResult(ctx context.Context, catalogVersion int64, searchKey string) (*models.SearchProductResult, error) {
+	var result models.SearchProductResult
+
+	cacheKey := r.key(fmt.Sprintf(cacheKeyProductSearch, catalogVersion, searchKey))
+	resultBytes, err := r.Redis.Get(ctx, cacheKey).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return &models.SearchProductResult{}, nil
+		}
+
+		return nil, err
+	}
+
+	err = r.Codec.Unmarshal(resultBytes, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *RedisCache) SetSearchResult(ctx context.Context, catalogVersion int64, searchKey string, result *models.SearchProductResult) error {
+	cacheKey := r.key(fmt.Sprintf(cacheKeyProductSearch, catalogVersion, searchKey))
+	resultBytes, err := r.Codec.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return r.Redis.SetEX(ctx, cacheKey, resultBytes, r.TTL.Search).Err()
+}
+
+// GetCatalogVersion returns the version that every cached search result is keyed by. The version is shared by
+// all schema versions and codecs, so it is kept outside of their namespaces.
+func (r *RedisCache) GetCatalogVersion(ctx context.Context) (int64, error) {
+	version, err := r.Redis.Get(ctx, cacheKeyCatalogVersion).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// BumpCatalogVersion invalidates all cached search results at once without touching their keys.
+func (r *RedisCache) BumpCatalogVersion(ctx context.Context) error {
+	return r.Redis.Incr(ctx, cacheKeyCatalogVersion).Err()
 }
 
-func (r *ProductRepository) DeleteProductCatCache(ctx context.Context, productCatId int) error {
-	cacheKey := fmt.Sprintf(cacheKeyProductCatInfo, productCatId)
-	return r.DeleteRedisCacheKey(ctx, cacheKey)
+func (r *RedisCache) DeleteProductCache(ctx context.Context, productId int) error {
+	return r.DeleteRedisCacheKey(ctx, allNamespaceKeys(productCacheKey(int64(productId)))...)
+}
+
+func (r *RedisCache) DeleteProductCatCache(ctx context.Context, productCatId int) error {
+	return r.DeleteRedisCacheKey(ctx, allNamespaceKeys(productCatCacheKey(int64(productCatId)))...)
+}
+
+func (r *RedisCache) DeleteRedisCacheKey(ctx context.Context, cacheKeys ...string) error {
+	return r.Redis.Del(ctx, cacheKeys...).Err()
+}
+
+// AcquireLock takes a short-lived lock shared by every instance. The returned token is needed to release it.
+func (r *RedisCache) AcquireLock(ctx context.Context, name string) (string, bool, error) {
+	token := uuid.NewString()
+	acquired, err := r.Redis.SetNX(ctx, r.key(fmt.Sprintf(cacheKeyLock, name)), token, cacheLockTTL).Result()
+	if err != nil {
+		return "", false, err
+	}
+
+	return token, acquired, nil
+}
+
+// ReleaseLock releases the lock only when it is still held with the given token.
+func (r *RedisCache) ReleaseLock(ctx context.Context, name string, token string) error {
+	return releaseLockScript.Run(ctx, r.Redis, []string{r.key(fmt.Sprintf(cacheKeyLock, name))}, token).Err()
+}
+
+// key places a key in the namespace of the current schema version and codec.
+func (r *RedisCache) key(key string) string {
+	return r.keyPrefix + key
+}
+
+// allNamespaceKeys returns the key in the namespace of every codec, for the current and the previous schema
+// version.
+func allNamespaceKeys(key string) []string {
+	keys := make([]string, 0, 2*len(cacheCodecNames))
+	for _, schemaVersion := range []int{cacheSchemaVersion, cacheSchemaVersion - 1} {
+		for _, codecName := range cacheCodecNames {
+			keys = append(keys, fmt.Sprintf(cacheKeyPrefix, schemaVersion, codecName)+key)
+		}
+	}
+	return keys
+}
+
+// productCacheKey and productCatCacheKey are the only places where keys of catalog entities are built. Each
+// entity has its own prefix, so a product and a category with the same id never share a cache entry.
+func productCacheKey(productId int64) string {
+	return fmt.Sprintf(cacheKeyProductInfo, productId)
+}
+
+func productCatCacheKey(productCatId int64) string {
+	return fmt.Sprintf(cacheKeyProductCatInfo, productCatId)
+}
+
+// productCacheEntry wraps a product in a cache entry and returns it with the ttl of its Redis key. The
+// freshness is jittered so products cached together do not all expire together.
+func (r *RedisCache) productCacheEntry(product *models.Product) ([]byte, time.Duration, error) {
+	freshFor := jitterTTL(r.TTL.Product)
+	entryBytes, err := r.Codec.Marshal(cacheEntry[models.Product]{
+		Value:      product,
+		FreshUntil: time.Now().Add(freshFor).Unix(),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entryBytes, freshFor + r.TTL.ProductStale, nil
 }
 
-func (r *ProductRepository) DeleteRedisCacheKey(ctx context.Context, cacheKey string) error {
-	return r.Redis.Del(ctx, cacheKey).Err()
+func jitterTTL(ttl time.Duration) time.Duration {
+	spread := int64(float64(ttl) * cacheTTLJitter)
+	return ttl - time.Duration(spread) + time.Duration(rand.Int63n(2*spread+1))
 }
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"product_commerce/config"
+	"product_commerce/models"
+	"reflect"
+	"testing"
+)
+
+func newTestRedisCache(t *testing.T) *RedisCache {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	return newTestRedisCacheWithCodec(t, server, CodecJSON)
+}
+
+func newTestRedisCacheWithCodec(t *testing.T, server *miniredis.Miniredis, codecName string) *RedisCache {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	codec, err := NewCodec(codecName)
+	if err != nil {
+		t.Fatalf("NewCodec() got error %v", err)
+	}
+	return NewRedisCache(client, codec, config.CacheTTLConfig{})
+}
+
+func TestEntityCacheKeysDiffer(t *testing.T) {
+	for _, id := range []int64{0, 1, 42} {
+		if productCacheKey(id) == productCatCacheKey(id) {
+			t.Errorf("product and category %d share the cache key %q", id, productCacheKey(id))
+		}
+	}
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestRedisCache(t)
+
+	product := &models.Product{ID: 1, Name: "Hammer", Stock: 5, CategoryID: 1, Price: 9.99, Version: 3}
+	productCat := &models.ProductCategory{ID: 1, Name: "Tools"}
+
+	err := cache.SetProductById(ctx, product)
+	if err != nil {
+		t.Fatalf("SetProductById() got error %v", err)
+	}
+	err = cache.SetProductCatById(ctx, productCat)
+	if err != nil {
+		t.Fatalf("SetProductCatById() got error %v", err)
+	}
+
+	cachedProduct, stale, err := cache.GetProductById(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetProductById() got error %v", err)
+	}
+	if stale {
+		t.Errorf("GetProductById() returned a stale product right after it was cached")
+	}
+	if !reflect.DeepEqual(cachedProduct, product) {
+		t.Errorf("GetProductById() = %+v, want %+v", cachedProduct, product)
+	}
+
+	cachedProductCat, err := cache.GetProductCatById(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetProductCatById() got error %v", err)
+	}
+	if !reflect.DeepEqual(cachedProductCat, productCat) {
+		t.Errorf("GetProductCatById() = %+v, want %+v", cachedProductCat, productCat)
+	}
+}
+
+func TestDeleteProductCatCacheKeepsProduct(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestRedisCache(t)
+
+	product := &models.Product{ID: 1, Name: "Hammer", CategoryID: 1}
+	err := cache.SetProductById(ctx, product)
+	if err != nil {
+		t.Fatalf("SetProductById() got error %v", err)
+	}
+	err = cache.SetProductCatById(ctx, &models.ProductCategory{ID: 1, Name: "Tools"})
+	if err != nil {
+		t.Fatalf("SetProductCatById() got error %v", err)
+	}
+
+	err = cache.DeleteProductCatCache(ctx, 1)
+	if err != nil {
+		t.Fatalf("DeleteProductCatCache() got error %v", err)
+	}
+
+	cachedProductCat, err := cache.GetProductCatById(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetProductCatById() got error %v", err)
+	}
+	if cachedProductCat.ID != 0 {
+		t.Errorf("category 1 is still cached after DeleteProductCatCache(1)")
+	}
+
+	cachedProduct, _, err := cache.GetProductById(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetProductById() got error %v", err)
+	}
+	if !reflect.DeepEqual(cachedProduct, product) {
+		t.Errorf("product 1 = %+v after DeleteProductCatCache(1), want %+v", cachedProduct, product)
+	}
+}
+
+func TestInvalidationReachesOtherCodecs(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	jsonCache := newTestRedisCacheWithCodec(t, server, CodecJSON)
+	msgpackCache := newTestRedisCacheWithCodec(t, server, CodecMsgpack)
+
+	err := jsonCache.SetProductById(ctx, &models.Product{ID: 1, Name: "Hammer"})
+	if err != nil {
+		t.Fatalf("SetProductById() got error %v", err)
+	}
+
+	err = msgpackCache.DeleteProductCache(ctx, 1)
+	if err != nil {
+		t.Fatalf("DeleteProductCache() got error %v", err)
+	}
+	cachedProduct, _, err := jsonCache.GetProductById(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetProductById() got error %v", err)
+	}
+	if cachedProduct.ID != 0 {
+		t.Errorf("product 1 is still cached with the json codec after a delete with the msgpack codec")
+	}
+
+	err = msgpackCache.BumpCatalogVersion(ctx)
+	if err != nil {
+		t.Fatalf("BumpCatalogVersion() got error %v", err)
+	}
+	version, err := jsonCache.GetCatalogVersion(ctx)
+	if err != nil {
+		t.Fatalf("GetCatalogVersion() got error %v", err)
+	}
+	if version != 1 {
+		t.Errorf("GetCatalogVersion() = %d with the json codec after a bump with the msgpack codec, want 1", version)
+	}
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"product_commerce/infra/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplicaHealthCheckInterval = 5 * time.Second
+	defaultReplicaMaxLag              = 2 * time.Second
+	defaultReadYourWritesWindow       = 5 * time.Second
+
+	replicaHealthCheckTimeout = time.Second
+)
+
+// replicaLagQuery returns how far a replica is behind its primary, 0 when it has replayed everything it received.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+type replica struct {
+	name     string
+	database *gorm.DB
+	healthy  atomic.Bool
+}
+
+// ReplicaSet routes reads to read replicas in turn. Replicas that fail their health check or lag behind by more
+// than maxLag are skipped until they recover. A client that wrote recently reads from the primary for a while
+// afterwards, so it always reads its own writes: the time of its last write travels with its requests, so this
+// holds whichever instance serves them. Entities written through this instance are also read from the primary
+// for that while, which covers callers that do not carry the time, e.g. jobs. Searches are only sent to the
+// primary for the client that wrote.
+type ReplicaSet struct {
+	replicas            []*replica
+	next                atomic.Uint64
+	maxLag              time.Duration
+	healthCheckInterval time.Duration
+	readYourWritesFor   time.Duration
+
+	writes sync.Map // key -> time.Time of the last write
+}
+
+func NewReplicaSet(databases []*gorm.DB, maxLag time.Duration, healthCheckInterval time.Duration, readYourWritesFor time.Duration) *ReplicaSet {
+	if maxLag <= 0 {
+		maxLag = defaultReplicaMaxLag
+	}
+	if healthCheckInterval <= 0 {
+		healthCheckInterval = defaultReplicaHealthCheckInterval
+	}
+	if readYourWritesFor <= 0 {
+		readYourWritesFor = defaultReadYourWritesWindow
+	}
+
+	set := &ReplicaSet{
+		maxLag:              maxLag,
+		healthCheckInterval: healthCheckInterval,
+		readYourWritesFor:   readYourWritesFor,
+	}
+	for i, database := range databases {
+		set.replicas = append(set.replicas, &replica{
+			name:     fmt.Sprintf("replica-%d", i),
+			database: database,
+		})
+	}
+	return set
+}
+
+// MaxLag is the furthest behind the primary a replica may be and still serve reads.
+func (s *ReplicaSet) MaxLag() time.Duration {
+	return s.maxLag
+}
+
+// Pick returns the next healthy replica, or nil when the read must go to the primary because none is healthy or
+// one of the keys was written recently.
+func (s *ReplicaSet) Pick(keys ...string) *gorm.DB {
+	for _, key := range keys {
+		if writtenAt, ok := s.writes.Load(key); ok && time.Since(writtenAt.(time.Time)) < s.readYourWritesFor {
+			return nil
+		}
+	}
+
+	for range s.replicas {
+		candidate := s.replicas[s.next.Add(1)%uint64(len(s.replicas))]
+		if candidate.healthy.Load() {
+			return candidate.database
+		}
+	}
+	return nil
+}
+
+// WrittenRecently reports whether a client whose last write was at lastWrite must still read from the primary.
+// A write in the future is never recent, or it would keep the client on the primary for good.
+func (s *ReplicaSet) WrittenRecently(lastWrite time.Time) bool {
+	age := time.Since(lastWrite)
+	return !lastWrite.IsZero() && age >= 0 && age < s.readYourWritesFor
+}
+
+// MarkWritten sends reads of the keys to the primary for the read-your-writes window.
+func (s *ReplicaSet) MarkWritten(keys ...string) {
+	now := time.Now()
+	for _, key := range keys {
+		s.writes.Store(key, now)
+	}
+}
+
+// Start checks every replica right away and then every interval until ctx is cancelled. Write marks older than
+// the read-your-writes window are dropped along the way.
+func (s *ReplicaSet) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, replica := range s.replicas {
+			s.check(ctx, replica)
+		}
+
+		s.writes.Range(func(key, writtenAt interface{}) bool {
+			if time.Since(writtenAt.(time.Time)) >= s.readYourWritesFor {
+				s.writes.Delete(key)
+			}
+			return true
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReplicaSet) check(ctx context.Context, replica *replica) {
+	ctx, cancel := context.WithTimeout(ctx, replicaHealthCheckTimeout)
+	defer cancel()
+
+	var lagSeconds float64
+	err := replica.database.WithContext(ctx).Raw(replicaLagQuery).Scan(&lagSeconds).Error
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	healthy := err == nil && lag <= s.maxLag
+
+	if replica.healthy.Swap(healthy) != healthy {
+		if healthy {
+			log.Logger.Infof("%s is healthy again", replica.name)
+		} else {
+			log.Logger.Errorf("%s taken out of rotation, lag %s, error %v", replica.name, lag, err)
+		}
+	}
+}
+
+// reader returns the connection for a read that a replica may serve. Reads inside a transaction, reads of a
+// client that wrote recently, reads of keys written recently and reads while no replica is healthy go to the
+// primary.
+func (r *ProductRepository) reader(ctx context.Context, keys ...string) *gorm.DB {
+	if r.Replicas == nil || inTransaction(ctx) {
+		return r.db(ctx)
+	}
+	if lastWrite, ok := ctx.Value("last_write").(time.Time); ok && r.Replicas.WrittenRecently(lastWrite) {
+		return r.db(ctx)
+	}
+
+	replica := r.Replicas.Pick(keys...)
+	if replica == nil {
+		return r.db(ctx)
+	}
+	return replica.WithContext(ctx)
+}
+
+// markProductsWritten records writes of products once they have committed: for the client that wrote them, and
+// for the reads of the products through this instance.
+func (r *ProductRepository) markProductsWritten(ctx context.Context, productIds ...int) {
+	keys := make([]string, 0, len(productIds))
+	for _, productId := range productIds {
+		keys = append(keys, productCacheKey(int64(productId)))
+	}
+	r.markWritten(ctx, keys...)
+}
+
+func (r *ProductRepository) markProductCatWritten(ctx context.Context, productCatId int) {
+	r.markWritten(ctx, productCatCacheKey(int64(productCatId)))
+}
+
+func (r *ProductRepository) markWritten(ctx context.Context, keys ...string) {
+	AfterCommit(ctx, func(ctx context.Context) {
+		// set by the request middleware, which hands the time to the client
+		if lastCommit, ok := ctx.Value("last_commit").(*atomic.Int64); ok {
+			lastCommit.Store(time.Now().UnixMilli())
+		}
+		if r.Replicas != nil {
+			r.Replicas.MarkWritten(keys...)
+		}
+	})
+}
@@ -2,37 +2,187 @@ package repository
 
 import (
 	"context"
-	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"product_commerce/models"
+	"sync"
+	"time"
 )
 
+// Criteria for ranking products with FindTopProductIds.
+const (
+	TopProductsByReserved = "reserved" // most units reserved recently
+	TopProductsByNewest   = "newest"
+	TopProductsByStock    = "stock"
+	TopProductsByPrice    = "price"
+
+	topProductsReservedWindow = 30 * 24 * time.Hour
+)
+
+// ProductStore is the persistent storage of the product catalog and its inventory.
+type ProductStore interface {
+	FindByProductId(ctx context.Context, productId int64) (*models.Product, error)
+	FindProductsByIds(ctx context.Context, productIds []int) ([]models.Product, error)
+	FindProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error)
+	FindAllProductCats(ctx context.Context) ([]models.ProductCategory, error)
+	FindTopProductIds(ctx context.Context, criteria string, limit int) ([]int, error)
+	FindBundleComponents(ctx context.Context, bundleIds []int) (map[int][]models.BundleComponent, error)
+	IsBundleComponent(ctx context.Context, productId int) (bool, error)
+	SearchProducts(ctx context.Context, searchParam *models.SearchProductParameter) ([]models.Product, int64, error)
+	SuggestProductName(ctx context.Context, term string) (string, error)
+
+	InsertNewProduct(ctx context.Context, product *models.Product) (int, error)
+	InsertNewProductCat(ctx context.Context, productCat *models.ProductCategory) (int, error)
+	InsertNewProducts(ctx context.Context, products []models.Product) error
+	InsertNewProductCats(ctx context.Context, productCats []models.ProductCategory) error
+	UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error)
+	UpdateProductCat(ctx context.Context, productCat *models.ProductCategory) (*models.ProductCategory, error)
+	DeleteProduct(ctx context.Context, id int) error
+	DeleteProductCat(ctx context.Context, id int) error
+	ReassignProductsCategory(ctx context.Context, fromCatId int, toCatId int) ([]int, error)
+
+	AdjustProductStock(ctx context.Context, productId int, quantity int, reason string) (*models.Product, error)
+	ReconcileStock(ctx context.Context, counts []models.StockCount, apply bool) (*models.StockReconciliation, error)
+	ReceiveLot(ctx context.Context, lot *models.ProductLot) (*models.Product, error)
+	WriteOffLot(ctx context.Context, lotId int) (*models.Product, error)
+	FindExpiringLots(ctx context.Context, expiresBefore time.Time) ([]models.ExpiringLot, error)
+
+	CaptureInventorySnapshot(ctx context.Context, snapshotDate time.Time) (int64, error)
+	FindLatestSnapshotDate(ctx context.Context, onOrBefore time.Time) (*time.Time, error)
+	FindInventorySnapshot(ctx context.Context, snapshotDate time.Time) ([]models.InventorySnapshot, error)
+	GetInventoryValuation(ctx context.Context, snapshotDate *time.Time) ([]models.CategoryValuation, error)
+
+	EnqueueCacheInvalidations(ctx context.Context, invalidations []models.PendingCacheInvalidation) error
+	FindDueCacheInvalidations(ctx context.Context, now time.Time, limit int) ([]models.PendingCacheInvalidation, error)
+	DeleteCacheInvalidation(ctx context.Context, id int64) error
+	RescheduleCacheInvalidation(ctx context.Context, invalidation *models.PendingCacheInvalidation) error
+
+	FindAuditEntries(ctx context.Context, filter *models.AuditFilter) ([]models.AuditEntry, int64, error)
+
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ProductCache keeps copies of catalog reads. A miss is reported as an empty value, not as an error.
+type ProductCache interface {
+	// GetProductById also reports whether the product is past its freshness and should be refreshed.
+	// It returns ErrNotFoundCached when the product is remembered as missing.
+	GetProductById(ctx context.Context, productId int64) (*models.Product, bool, error)
+	SetProductById(ctx context.Context, product *models.Product) error
+	SetProductNotFound(ctx context.Context, productId int64) error
+	GetProducts(ctx context.Context, productIds []int) (map[int]models.Product, []int, error)
+	SetProducts(ctx context.Context, products []models.Product) error
+	DeleteProductCache(ctx context.Context, productId int) error
+
+	GetProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error)
+	SetProductCatById(ctx context.Context, productCat *models.ProductCategory) error
+	SetProductCats(ctx context.Context, productCats []models.ProductCategory) error
+	DeleteProductCatCache(ctx context.Context, productCatId int) error
+
+	GetSearchResult(ctx context.Context, catalogVersion int64, searchKey string) (*models.SearchProductResult, error)
+	SetSearchResult(ctx context.Context, catalogVersion int64, searchKey string, result *models.SearchProductResult) error
+	GetCatalogVersion(ctx context.Context) (int64, error)
+	BumpCatalogVersion(ctx context.Context) error
+
+	AcquireLock(ctx context.Context, name string) (string, bool, error)
+	ReleaseLock(ctx context.Context, name string, token string) error
+}
+
+var (
+	_ ProductStore = (*ProductRepository)(nil)
+	_ ProductStore = (*CachedProductStore)(nil)
+	_ ProductCache = (*RedisCache)(nil)
+	_ ProductCache = (*TieredCache)(nil)
+	_ ProductCache = (*MemoryCache)(nil)
+)
+
+// defaultFuzzyThreshold is the trigram similarity, between 0 and 1, a fuzzy match needs by default.
+const defaultFuzzyThreshold = 0.3
+
 type ProductRepository struct {
-	Database *gorm.DB
-	Redis    *redis.Client
+	Database       *gorm.DB
+	Replicas       *ReplicaSet // optional, serves the reads that tolerate replication lag
+	FuzzyThreshold float64
 }
 
-func NewProductRepo(db *gorm.DB, redis *redis.Client) *ProductRepository {
+func NewProductRepo(db *gorm.DB, replicas *ReplicaSet, fuzzyThreshold float64) *ProductRepository {
+	if fuzzyThreshold <= 0 || fuzzyThreshold > 1 {
+		fuzzyThreshold = defaultFuzzyThreshold
+	}
+
 	return &ProductRepository{
-		Database: db,
-		Redis:    redis,
+		Database:       db,
+		Replicas:       replicas,
+		FuzzyThreshold: fuzzyThreshold,
 	}
 }
 
-func (r *ProductRepository) WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
-	tx := r.Database.Begin().WithContext(ctx)
-
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-			panic(r)
-		}
-	}()
+// WithTransaction runs fn as one unit of work. Every repository call made with the context passed to fn runs in
+// the same transaction, and a nested call runs in a savepoint of the outer transaction. Hooks registered with
+// AfterCommit run only once the outermost transaction has committed.
+func (r *ProductRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	hooks, nested := ctx.Value(afterCommitKey{}).(*afterCommitHooks)
+	if !nested {
+		hooks = &afterCommitHooks{}
+		ctx = context.WithValue(ctx, afterCommitKey{}, hooks)
+	}
 
-	err := fn(tx)
-	if r != nil {
-		tx.Rollback()
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+	if err != nil {
 		return err
 	}
 
-	return tx.Commit().Error
+	if !nested {
+		// the hooks run outside of the finished transaction, so they must not join its hook list either
+		hooks.run(context.WithValue(context.WithoutCancel(ctx), afterCommitKey{}, nil))
+	}
+	return nil
+}
+
+type txKey struct{}
+
+// db returns the transaction carried by ctx, or the database when there is none.
+func (r *ProductRepository) db(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.Database.WithContext(ctx)
+}
+
+// inTransaction reports whether ctx carries a transaction opened by WithTransaction.
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return ok
+}
+
+type afterCommitKey struct{}
+
+type afterCommitHooks struct {
+	mu    sync.Mutex
+	hooks []func(ctx context.Context)
+}
+
+func (h *afterCommitHooks) run(ctx context.Context) {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.hooks = nil
+	h.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+// AfterCommit defers hook until the transaction carried by ctx has committed, and drops it when the transaction
+// is rolled back. Outside of a transaction the hook runs immediately.
+func AfterCommit(ctx context.Context, hook func(ctx context.Context)) {
+	hooks, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks)
+	if !ok {
+		hook(ctx)
+		return
+	}
+
+	hooks.mu.Lock()
+	hooks.hooks = append(hooks.hooks, hook)
+	hooks.mu.Unlock()
 }
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"product_commerce/infra/log"
+	"product_commerce/models"
+	"time"
+)
+
+const (
+	cacheInvalidationChannel = "catalog-invalidation"
+	defaultLocalCacheTTL     = 30 * time.Second
+)
+
+const (
+	invalidationKindProduct    = "product"
+	invalidationKindProductCat = "product-category"
+)
+
+type cacheInvalidation struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id"`
+}
+
+// TieredCache keeps the hottest products and categories in process memory in front of a shared cache.
+// Invalidations are published over Redis pub/sub so every instance drops its local copy; the local ttl bounds
+// how stale an instance can get if it misses a message.
+type TieredCache struct {
+	ProductCache
+	Redis *redis.Client
+
+	products    *lruCache[models.Product]
+	productCats *lruCache[models.ProductCategory]
+}
+
+func NewTieredCache(remote ProductCache, redis *redis.Client, size int, ttl time.Duration) *TieredCache {
+	if ttl <= 0 {
+		ttl = defaultLocalCacheTTL
+	}
+
+	return &TieredCache{
+		ProductCache: remote,
+		Redis:        redis,
+		products:     newLRUCache[models.Product](size, ttl),
+		productCats:  newLRUCache[models.ProductCategory](size, ttl),
+	}
+}
+
+func (c *TieredCache) GetProductById(ctx context.Context, productId int64) (*models.Product, bool, error) {
+	key := productCacheKey(productId)
+	if product, ok := c.products.Get(key); ok {
+		return &product, false, nil
+	}
+
+	product, stale, err := c.ProductCache.GetProductById(ctx, productId)
+	if err != nil {
+		return product, false, err
+	}
+
+	// a stale product is about to be refreshed and must not be pinned locally
+	if product.ID != 0 && !stale {
+		c.products.Set(key, *product)
+	}
+	return product, stale, nil
+}
+
+// GetProducts serves what it can from memory and reads only the remaining ids from the shared cache.
+func (c *TieredCache) GetProducts(ctx context.Context, productIds []int) (map[int]models.Product, []int, error) {
+	products := make(map[int]models.Product, len(productIds))
+	var remoteIds []int
+	for _, productId := range productIds {
+		if product, ok := c.products.Get(productCacheKey(int64(productId))); ok {
+			products[productId] = product
+			continue
+		}
+		remoteIds = append(remoteIds, productId)
+	}
+
+	if len(remoteIds) == 0 {
+		return products, nil, nil
+	}
+
+	remoteProducts, notFoundIds, err := c.ProductCache.GetProducts(ctx, remoteIds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for productId, product := range remoteProducts {
+		c.products.Set(productCacheKey(int64(productId)), product)
+		products[productId] = product
+	}
+	return products, notFoundIds, nil
+}
+
+func (c *TieredCache) SetProductById(ctx context.Context, product *models.Product) error {
+	err := c.ProductCache.SetProductById(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	c.products.Set(productCacheKey(int64(product.ID)), *product)
+	return nil
+}
+
+func (c *TieredCache) DeleteProductCache(ctx context.Context, productId int) error {
+	c.products.Delete(productCacheKey(int64(productId)))
+
+	err := c.ProductCache.DeleteProductCache(ctx, productId)
+	if err != nil {
+		return err
+	}
+
+	return c.publish(ctx, cacheInvalidation{Kind: invalidationKindProduct, ID: productId})
+}
+
+func (c *TieredCache) GetProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
+	key := productCatCacheKey(productCatId)
+	if productCat, ok := c.productCats.Get(key); ok {
+		return &productCat, nil
+	}
+
+	productCat, err := c.ProductCache.GetProductCatById(ctx, productCatId)
+	if err != nil {
+		return nil, err
+	}
+
+	if productCat.ID != 0 {
+		c.productCats.Set(key, *productCat)
+	}
+	return productCat, nil
+}
+
+func (c *TieredCache) SetProductCatById(ctx context.Context, productCat *models.ProductCategory) error {
+	err := c.ProductCache.SetProductCatById(ctx, productCat)
+	if err != nil {
+		return err
+	}
+
+	c.productCats.Set(productCatCacheKey(int64(productCat.ID)), *productCat)
+	return nil
+}
+
+func (c *TieredCache) DeleteProductCatCache(ctx context.Context, productCatId int) error {
+	c.productCats.Delete(productCatCacheKey(int64(productCatId)))
+
+	err := c.ProductCache.DeleteProductCatCache(ctx, productCatId)
+	if err != nil {
+		return err
+	}
+
+	return c.publish(ctx, cacheInvalidation{Kind: invalidationKindProductCat, ID: productCatId})
+}
+
+// Subscribe drops local entries invalidated by any instance until ctx is cancelled.
+func (c *TieredCache) Subscribe(ctx context.Context) {
+	pubsub := c.Redis.Subscribe(ctx, cacheInvalidationChannel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var invalidation cacheInvalidation
+			err := json.Unmarshal([]byte(message.Payload), &invalidation)
+			if err != nil {
+				log.Logger.WithFields(logrus.Fields{
+					"payload": message.Payload,
+				}).Errorf("json.Unmarshal cache invalidation got error %v", err)
+				continue
+			}
+
+			switch invalidation.Kind {
+			case invalidationKindProduct:
+				c.products.Delete(productCacheKey(int64(invalidation.ID)))
+			case invalidationKindProductCat:
+				c.productCats.Delete(productCatCacheKey(int64(invalidation.ID)))
+			}
+		}
+	}
+}
+
+func (c *TieredCache) publish(ctx context.Context, invalidation cacheInvalidation) error {
+	payload, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+
+	return c.Redis.Publish(ctx, cacheInvalidationChannel, payload).Err()
+}
@@ -1,27 +1,49 @@
 package resource
 
 import (
+	"database/sql"
 	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	_ "gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	_ "gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	_ "gorm.io/gorm/logger"
 	_ "honnef.co/go/tools/config"
 	"log"
 	"product_commerce/config"
+	infralog "product_commerce/infra/log"
+	"strconv"
+)
+
+// Database drivers selectable with database.driver.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
 func InitDB(cfg *config.Config) *gorm.DB {
 	fmt.Print(cfg)
+	if cfg.Database.Driver == DriverSQLite {
+		db, err := openSQLite(cfg)
+		if err != nil {
+			log.Fatalf("failed to open sqlite db %s", err)
+		}
+
+		log.Printf("opened sqlite db %s", cfg.Database.Name)
+		return db
+	}
+	if cfg.Database.Driver != "" && cfg.Database.Driver != DriverPostgres {
+		log.Fatalf("unknown database driver %q, expected postgres or sqlite", cfg.Database.Driver)
+	}
+
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.Name)
 
 	fmt.Print(dsn)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
+	db, err := openDB(cfg, dsn)
 
 	if err != nil {
 		log.Fatalf("failed to connect with db %s", err)
@@ -30,3 +52,97 @@ func InitDB(cfg *config.Config) *gorm.DB {
 	log.Print("connected with db")
 	return db
 }
+
+// InitReplicas connects to every configured read replica. A replica that cannot be reached is left out so the
+// service still starts on the primary alone. SQLite has no replicas.
+func InitReplicas(cfg *config.Config) []*gorm.DB {
+	var replicas []*gorm.DB
+	if cfg.Database.Driver == DriverSQLite {
+		if len(cfg.Database.Replicas.DSNs) > 0 {
+			log.Print("ignoring replicas, the sqlite driver reads from its single file")
+		}
+		return replicas
+	}
+
+	for i, dsn := range cfg.Database.Replicas.DSNs {
+		db, err := openDB(cfg, dsn)
+		if err != nil {
+			log.Printf("failed to connect with replica %d %s", i, err)
+			continue
+		}
+
+		replicas = append(replicas, db)
+	}
+
+	log.Printf("connected with %d replicas", len(replicas))
+	return replicas
+}
+
+// openDB connects with the statement timeout set on every connection of the pool, sizes the pool and logs SQL
+// through the application logger.
+func openDB(cfg *config.Config, dsn string) (*gorm.DB, error) {
+	logLevel, err := infralog.ParseGormLogLevel(cfg.Database.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Database.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.Database.StatementTimeout.Milliseconds(), 10)
+	}
+
+	sqlDB := stdlib.OpenDB(*connConfig)
+	configurePool(sqlDB, cfg.Database.Pool)
+
+	return gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: infralog.NewGormLogger(logLevel, cfg.Database.SlowQueryThreshold),
+	})
+}
+
+func configurePool(sqlDB *sql.DB, pool config.PoolConfig) {
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+}
+
+// openSQLite opens the database file named by database.name, or a private in-memory database for ":memory:".
+// SQLite allows one writer at a time, so the pool holds a single connection that is never closed: closing it
+// would also drop an in-memory database.
+func openSQLite(cfg *config.Config) (*gorm.DB, error) {
+	logLevel, err := infralog.ParseGormLogLevel(cfg.Database.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on", cfg.Database.Name)
+	if cfg.Database.StatementTimeout > 0 {
+		// the closest SQLite has to a statement timeout: how long to wait for the write lock
+		dsn += fmt.Sprintf("&_busy_timeout=%d", cfg.Database.StatementTimeout.Milliseconds())
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: infralog.NewGormLogger(logLevel, cfg.Database.SlowQueryThreshold),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	return db, nil
+}
@@ -8,6 +8,12 @@ import (
 	"product_commerce/config"
 )
 
+// Cache drivers selectable with cache.driver.
+const (
+	CacheDriverRedis  = "redis"
+	CacheDriverMemory = "memory"
+)
+
 var RedisClient *redis.Client
 
 func InitRedis(cfg *config.Config) *redis.Client {
@@ -3,55 +3,58 @@ package service
 import (
 	"context"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"gorm.io/gorm"
 	"product_commerce/cmd/product/repository"
-	"product_commerce/infra/log"
 	"product_commerce/models"
+	"time"
 )
 
 type ProductService struct {
-	ProductRepository repository.ProductRepository
+	ProductStore repository.ProductStore
 }
 
-func NewProductService(productRepo repository.ProductRepository) *ProductService {
+func NewProductService(productStore repository.ProductStore) *ProductService {
 	return &ProductService{
-		ProductRepository: productRepo,
+		ProductStore: productStore,
 	}
 }
 
 func (s *ProductService) GetProductById(ctx context.Context, productId int64) (*models.Product, error) {
-	product, err := s.ProductRepository.GetProductByIdFromRedis(ctx, productId)
+	product, err := s.ProductStore.FindByProductId(ctx, productId)
 	if err != nil {
-		log.Logger.WithFields(logrus.Fields{
-			"error": fmt.Sprintf("got error on s.ProductRepository.GetProductByIdFromRedis: %d", err),
-			"id":    productId,
-		})
+		return nil, err
 	}
 
-	if product.ID != 0 {
-		return product, nil
+	// bundle stock always comes from the live stock of its components
+	if product.IsBundle {
+		componentsByBundle, err := s.ProductStore.FindBundleComponents(ctx, []int{product.ID})
+		if err != nil {
+			return nil, err
+		}
+		product.Components = componentsByBundle[product.ID]
+		product.ResolveBundleStock()
 	}
+	product.Availability = product.ResolveAvailability()
+
+	return product, nil
+}
 
-	product, err = s.ProductRepository.FindByProductId(ctx, productId)
+// GetCurrentProductById reads the product like GetProductById, but from the primary and past the cache, e.g. to
+// show a client the version its write conflicted with.
+func (s *ProductService) GetCurrentProductById(ctx context.Context, productId int64) (*models.Product, error) {
+	var product *models.Product
+	err := s.ProductStore.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		product, err = s.GetProductById(ctx, productId)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	ctxConcurrent := context.WithValue(context.Background(), "request_id", ctx.Value("request_id"))
-	go func(ctx context.Context, product *models.Product) {
-		errConcurrent := s.ProductRepository.SetProductById(ctx, product)
-		if errConcurrent != nil {
-			log.Logger.WithFields(logrus.Fields{
-				"product": product,
-			}).Errorf("s.ProductRepository.SetProductByID() got error %v", errConcurrent)
-		}
-	}(ctxConcurrent, product)
 	return product, nil
 }
 
 func (s *ProductService) GetProductCatById(ctx context.Context, productCatId int64) (*models.ProductCategory, error) {
-	productCat, err := s.ProductRepository.FindProductCatById(ctx, productCatId)
+	productCat, err := s.ProductStore.FindProductCatById(ctx, productCatId)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +62,12 @@ func (s *ProductService) GetProductCatById(ctx context.Context, productCatId int
 }
 
 func (s *ProductService) CreateProduct(ctx context.Context, product *models.Product) (int, error) {
-	id, err := s.ProductRepository.InsertNewProduct(ctx, product)
+	err := s.validateBundle(ctx, product)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := s.ProductStore.InsertNewProduct(ctx, product)
 	if err != nil {
 		return 0, err
 	}
@@ -67,7 +75,7 @@ func (s *ProductService) CreateProduct(ctx context.Context, product *models.Prod
 }
 
 func (s *ProductService) CreateProductCat(ctx context.Context, productCat *models.ProductCategory) (int, error) {
-	id, err := s.ProductRepository.InsertNewProductCat(ctx, productCat)
+	id, err := s.ProductStore.InsertNewProductCat(ctx, productCat)
 	if err != nil {
 		return 0, err
 	}
@@ -75,19 +83,19 @@ func (s *ProductService) CreateProductCat(ctx context.Context, productCat *model
 }
 
 func (s *ProductService) UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
+	err := s.validateBundle(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
 	var model *models.Product
-	err := s.ProductRepository.WithTransaction(ctx, func(tx *gorm.DB) error {
-		productDetail, err := s.ProductRepository.UpdateProduct(ctx, product)
+	err = s.ProductStore.WithTransaction(ctx, func(ctx context.Context) error {
+		productDetail, err := s.ProductStore.UpdateProduct(ctx, product)
 		if err != nil {
 			return err
 		}
 		model = productDetail
 
-		err = s.ProductRepository.SetProductById(ctx, product)
-		if err != nil {
-			return err
-		}
-
 		return nil
 	})
 
@@ -100,17 +108,13 @@ func (s *ProductService) UpdateProduct(ctx context.Context, product *models.Prod
 
 func (s *ProductService) UpdateProductCat(ctx context.Context, productCat *models.ProductCategory) (*models.ProductCategory, error) {
 	var model *models.ProductCategory
-	err := s.ProductRepository.WithTransaction(ctx, func(tx *gorm.DB) error {
-		productCatDetail, err := s.ProductRepository.UpdateProductCat(ctx, productCat)
+	err := s.ProductStore.WithTransaction(ctx, func(ctx context.Context) error {
+		productCatDetail, err := s.ProductStore.UpdateProductCat(ctx, productCat)
 		if err != nil {
 			return err
 		}
 		model = productCatDetail
 
-		err = s.ProductRepository.DeleteProductCache(ctx, productCatDetail.ID)
-		if err != nil {
-			return err
-		}
 		return nil
 	})
 
@@ -121,13 +125,8 @@ func (s *ProductService) UpdateProductCat(ctx context.Context, productCat *model
 }
 
 func (s *ProductService) DeleteProduct(ctx context.Context, productId int) error {
-	err := s.ProductRepository.WithTransaction(ctx, func(tx *gorm.DB) error {
-		err := s.ProductRepository.DeleteProduct(ctx, productId)
-		if err != nil {
-			return err
-		}
-
-		err = s.ProductRepository.DeleteProductCache(ctx, productId)
+	err := s.ProductStore.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.ProductStore.DeleteProduct(ctx, productId)
 		if err != nil {
 			return err
 		}
@@ -141,8 +140,32 @@ func (s *ProductService) DeleteProduct(ctx context.Context, productId int) error
 	return nil
 }
 
-func (s *ProductService) DeleteProductCat(ctx context.Context, productCatId int) error {
-	err := s.ProductRepository.DeleteProductCat(ctx, productCatId)
+// DeleteProductCat deletes a category. When reassignTo is set, the products of the category are first moved to
+// that category; both steps commit together or not at all.
+func (s *ProductService) DeleteProductCat(ctx context.Context, productCatId int, reassignTo int) error {
+	err := s.ProductStore.WithTransaction(ctx, func(ctx context.Context) error {
+		if reassignTo != 0 {
+			if reassignTo == productCatId {
+				return fmt.Errorf("%w: products cannot be reassigned to the deleted category", models.ErrInvalidCategory)
+			}
+
+			target, err := s.ProductStore.FindProductCatById(ctx, int64(reassignTo))
+			if err != nil {
+				return err
+			}
+			if target.ID == 0 {
+				return fmt.Errorf("%w: category %d does not exist", models.ErrInvalidCategory, reassignTo)
+			}
+
+			_, err = s.ProductStore.ReassignProductsCategory(ctx, productCatId, reassignTo)
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.ProductStore.DeleteProductCat(ctx, productCatId)
+	})
+
 	if err != nil {
 		return err
 	}
@@ -150,9 +173,238 @@ func (s *ProductService) DeleteProductCat(ctx context.Context, productCatId int)
 }
 
 func (s *ProductService) GetProductList(ctx context.Context, paramRequest *models.SearchProductParameter) ([]models.Product, int64, error) {
-	products, total, err := s.ProductRepository.SearchProducts(ctx, paramRequest)
+	products, total, err := s.ProductStore.SearchProducts(ctx, paramRequest)
+	if err != nil {
+		return []models.Product{}, 0, err
+	}
+
+	err = s.resolveProducts(ctx, products)
 	if err != nil {
 		return []models.Product{}, 0, err
 	}
 	return products, total, nil
 }
+
+func (s *ProductService) SuggestProductName(ctx context.Context, term string) (string, error) {
+	return s.ProductStore.SuggestProductName(ctx, term)
+}
+
+// GetProductsByIds returns the existing products among productIds, ordered by id, and the ids that do not exist.
+func (s *ProductService) GetProductsByIds(ctx context.Context, productIds []int) ([]models.Product, []int, error) {
+	products, err := s.ProductStore.FindProductsByIds(ctx, productIds)
+	if err != nil {
+		return []models.Product{}, []int{}, err
+	}
+
+	err = s.resolveProducts(ctx, products)
+	if err != nil {
+		return []models.Product{}, []int{}, err
+	}
+
+	found := make(map[int]bool, len(products))
+	for _, product := range products {
+		found[product.ID] = true
+	}
+
+	notFoundIds := []int{}
+	for _, productId := range productIds {
+		if !found[productId] {
+			notFoundIds = append(notFoundIds, productId)
+		}
+	}
+	return products, notFoundIds, nil
+}
+
+// resolveProducts derives the stock of bundles from their components and the availability of every product.
+func (s *ProductService) resolveProducts(ctx context.Context, products []models.Product) error {
+	var bundleIds []int
+	for _, product := range products {
+		if product.IsBundle {
+			bundleIds = append(bundleIds, product.ID)
+		}
+	}
+
+	if len(bundleIds) > 0 {
+		componentsByBundle, err := s.ProductStore.FindBundleComponents(ctx, bundleIds)
+		if err != nil {
+			return err
+		}
+
+		for i := range products {
+			if products[i].IsBundle {
+				products[i].Components = componentsByBundle[products[i].ID]
+				products[i].ResolveBundleStock()
+			}
+		}
+	}
+
+	for i := range products {
+		products[i].Availability = products[i].ResolveAvailability()
+	}
+	return nil
+}
+
+func (s *ProductService) AdjustStock(ctx context.Context, productId int, quantity int, reason string) (*models.Product, error) {
+	product, err := s.ProductStore.AdjustProductStock(ctx, productId, quantity, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Availability = product.ResolveAvailability()
+	return product, nil
+}
+
+func (s *ProductService) ReconcileStock(ctx context.Context, counts []models.StockCount, apply bool) (*models.StockReconciliation, error) {
+	result, err := s.ProductStore.ReconcileStock(ctx, counts, apply)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *ProductService) CaptureInventorySnapshot(ctx context.Context, snapshotDate time.Time) (int64, error) {
+	return s.ProductStore.CaptureInventorySnapshot(ctx, snapshotDate)
+}
+
+// GetInventorySnapshot returns the latest snapshot taken on or before the given date, so a day whose capture
+// failed reports the stock as last captured. It returns ErrSnapshotNotFound when no snapshot is that old.
+func (s *ProductService) GetInventorySnapshot(ctx context.Context, snapshotDate time.Time) ([]models.InventorySnapshot, error) {
+	latestDate, err := s.latestSnapshotDate(ctx, snapshotDate)
+	if err != nil {
+		return []models.InventorySnapshot{}, err
+	}
+
+	snapshots, err := s.ProductStore.FindInventorySnapshot(ctx, *latestDate)
+	if err != nil {
+		return []models.InventorySnapshot{}, err
+	}
+	return snapshots, nil
+}
+
+// GetInventoryValuation values the live stock, or the latest snapshot taken on or before the given date. The
+// report carries the date of the snapshot it was computed from.
+func (s *ProductService) GetInventoryValuation(ctx context.Context, snapshotDate *time.Time) (*models.InventoryValuationReport, error) {
+	if snapshotDate != nil {
+		latestDate, err := s.latestSnapshotDate(ctx, *snapshotDate)
+		if err != nil {
+			return nil, err
+		}
+		snapshotDate = latestDate
+	}
+
+	valuations, err := s.ProductStore.GetInventoryValuation(ctx, snapshotDate)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.InventoryValuationReport{
+		Date:       time.Now().Format(time.DateOnly),
+		Categories: valuations,
+	}
+	if snapshotDate != nil {
+		report.Date = snapshotDate.Format(time.DateOnly)
+	}
+
+	for _, valuation := range valuations {
+		report.TotalValue += valuation.TotalValue
+	}
+	return report, nil
+}
+
+func (s *ProductService) latestSnapshotDate(ctx context.Context, onOrBefore time.Time) (*time.Time, error) {
+	latestDate, err := s.ProductStore.FindLatestSnapshotDate(ctx, onOrBefore)
+	if err != nil {
+		return nil, err
+	}
+	if latestDate == nil {
+		return nil, fmt.Errorf("%w %s", models.ErrSnapshotNotFound, onOrBefore.Format(time.DateOnly))
+	}
+	return latestDate, nil
+}
+
+// validateBundle checks that a bundle is made of existing, non-bundle products other than itself, and that it
+// is not a component of another bundle: bundle stock is resolved one level deep only.
+func (s *ProductService) validateBundle(ctx context.Context, product *models.Product) error {
+	if !product.IsBundle {
+		product.Components = nil
+		return nil
+	}
+
+	if len(product.Components) == 0 {
+		return fmt.Errorf("%w: a bundle needs at least one component", models.ErrInvalidBundle)
+	}
+
+	if product.ID != 0 {
+		isComponent, err := s.ProductStore.IsBundleComponent(ctx, product.ID)
+		if err != nil {
+			return err
+		}
+		if isComponent {
+			return fmt.Errorf("%w: product %d is a component of another bundle", models.ErrInvalidBundle, product.ID)
+		}
+	}
+
+	componentIds := make([]int, 0, len(product.Components))
+	seen := make(map[int]bool, len(product.Components))
+	for _, component := range product.Components {
+		if component.Quantity <= 0 {
+			return fmt.Errorf("%w: component %d must have a positive quantity", models.ErrInvalidBundle, component.ComponentID)
+		}
+		if component.ComponentID == product.ID || seen[component.ComponentID] {
+			return fmt.Errorf("%w: component %d is listed more than once or refers to the bundle itself", models.ErrInvalidBundle, component.ComponentID)
+		}
+		seen[component.ComponentID] = true
+		componentIds = append(componentIds, component.ComponentID)
+	}
+
+	components, err := s.ProductStore.FindProductsByIds(ctx, componentIds)
+	if err != nil {
+		return err
+	}
+
+	if len(components) != len(componentIds) {
+		return fmt.Errorf("%w: one or more components do not exist", models.ErrInvalidBundle)
+	}
+	for _, component := range components {
+		if component.IsBundle {
+			return fmt.Errorf("%w: component %d is a bundle itself", models.ErrInvalidBundle, component.ID)
+		}
+	}
+	return nil
+}
+
+func (s *ProductService) ReceiveLot(ctx context.Context, lot *models.ProductLot) (*models.Product, error) {
+	product, err := s.ProductStore.ReceiveLot(ctx, lot)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Availability = product.ResolveAvailability()
+	return product, nil
+}
+
+func (s *ProductService) WriteOffLot(ctx context.Context, lotId int) (*models.Product, error) {
+	product, err := s.ProductStore.WriteOffLot(ctx, lotId)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Availability = product.ResolveAvailability()
+	return product, nil
+}
+
+func (s *ProductService) GetExpiringLots(ctx context.Context, expiresBefore time.Time) ([]models.ExpiringLot, error) {
+	lots, err := s.ProductStore.FindExpiringLots(ctx, expiresBefore)
+	if err != nil {
+		return []models.ExpiringLot{}, err
+	}
+	return lots, nil
+}
+
+func (s *ProductService) GetAuditEntries(ctx context.Context, filter *models.AuditFilter) ([]models.AuditEntry, int64, error) {
+	entries, total, err := s.ProductStore.FindAuditEntries(ctx, filter)
+	if err != nil {
+		return []models.AuditEntry{}, 0, err
+	}
+	return entries, total, nil
+}
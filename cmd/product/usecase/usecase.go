@@ -2,10 +2,16 @@ package usecase
 
 import (
 	"context"
+	"encoding/csv"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
 	"product_commerce/cmd/product/service"
 	"product_commerce/infra/log"
 	"product_commerce/models"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ProductUseCase struct {
@@ -27,6 +33,18 @@ func (uc *ProductUseCase) GetProductById(ctx context.Context, productID int64) (
 	return product, nil
 }
 
+func (uc *ProductUseCase) GetCurrentProductById(ctx context.Context, productID int64) (*models.Product, error) {
+	product, err := uc.ProductService.GetCurrentProductById(ctx, productID)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": productID,
+		}).Errorf("uc.ProductService.GetCurrentProductById got error %v", err)
+		return nil, err
+	}
+
+	return product, nil
+}
+
 func (uc *ProductUseCase) GetProductCatById(ctx context.Context, productCategoryID int64) (*models.ProductCategory, error) {
 	productCategory, err := uc.ProductService.GetProductCatById(ctx, productCategoryID)
 	if err != nil {
@@ -36,6 +54,11 @@ func (uc *ProductUseCase) GetProductCatById(ctx context.Context, productCategory
 }
 
 func (uc *ProductUseCase) CreateProduct(ctx context.Context, param *models.Product) (int, error) {
+	err := validateInventoryPolicy(param)
+	if err != nil {
+		return 0, err
+	}
+
 	productID, err := uc.ProductService.CreateProduct(ctx, param)
 	if err != nil {
 		log.Logger.WithFields(logrus.Fields{
@@ -61,6 +84,11 @@ func (uc *ProductUseCase) CreateNewProductCategory(ctx context.Context, param *m
 }
 
 func (uc *ProductUseCase) UpdateProduct(ctx context.Context, param *models.Product) (*models.Product, error) {
+	err := validateInventoryPolicy(param)
+	if err != nil {
+		return nil, err
+	}
+
 	product, err := uc.ProductService.UpdateProduct(ctx, param)
 	if err != nil {
 		return nil, err
@@ -87,8 +115,8 @@ func (uc *ProductUseCase) DeleteProduct(ctx context.Context, productID int) erro
 	return nil
 }
 
-func (uc *ProductUseCase) DeleteProductCat(ctx context.Context, productCategoryID int) error {
-	err := uc.ProductService.DeleteProductCat(ctx, productCategoryID)
+func (uc *ProductUseCase) DeleteProductCat(ctx context.Context, productCategoryID int, reassignTo int) error {
+	err := uc.ProductService.DeleteProductCat(ctx, productCategoryID, reassignTo)
 	if err != nil {
 		return err
 	}
@@ -96,14 +124,256 @@ func (uc *ProductUseCase) DeleteProductCat(ctx context.Context, productCategoryI
 	return nil
 }
 
-func (uc *ProductUseCase) SearchProduct(ctx context.Context, searchParam *models.SearchProductParameter) ([]models.Product, int64, error) {
+// SearchProduct returns one page of results. When an exact search by text finds nothing, the closest product
+// name is suggested instead.
+func (uc *ProductUseCase) SearchProduct(ctx context.Context, searchParam *models.SearchProductParameter) (*models.SearchProductResult, error) {
 	products, total, err := uc.ProductService.GetProductList(ctx, searchParam)
 	if err != nil {
 		log.Logger.WithFields(logrus.Fields{
 			"searchParam": searchParam,
 		}).Errorf("uc.ProductService.GetProductList got error %v", err)
-		return []models.Product{}, 0, err
+		return nil, err
+	}
+
+	result := &models.SearchProductResult{
+		Products:   products,
+		TotalCount: total,
+	}
+
+	term := searchParam.Query
+	if term == "" {
+		term = searchParam.Name
+	}
+	if total == 0 && !searchParam.Fuzzy && term != "" {
+		// a missing suggestion must not fail the search itself
+		result.DidYouMean, err = uc.ProductService.SuggestProductName(ctx, term)
+		if err != nil {
+			log.Logger.WithFields(logrus.Fields{
+				"term": term,
+			}).Errorf("uc.ProductService.SuggestProductName got error %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+func (uc *ProductUseCase) GetProductsByIds(ctx context.Context, productIds []int) ([]models.Product, []int, error) {
+	// each id is read once however often it is requested
+	uniqueIds := make([]int, 0, len(productIds))
+	seen := make(map[int]bool, len(productIds))
+	for _, productId := range productIds {
+		if !seen[productId] {
+			seen[productId] = true
+			uniqueIds = append(uniqueIds, productId)
+		}
+	}
+
+	products, notFoundIds, err := uc.ProductService.GetProductsByIds(ctx, uniqueIds)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_ids": uniqueIds,
+		}).Errorf("uc.ProductService.GetProductsByIds got error %v", err)
+		return []models.Product{}, []int{}, err
+	}
+
+	return products, notFoundIds, nil
+}
+
+func (uc *ProductUseCase) AdjustStock(ctx context.Context, param *models.StockManagementParameter) (*models.Product, error) {
+	product, err := uc.ProductService.AdjustStock(ctx, param.ProductID, param.Quantity, param.Reason)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"param": param,
+		}).Errorf("uc.ProductService.AdjustStock got error %v", err)
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (uc *ProductUseCase) ReserveStock(ctx context.Context, productID int, quantity int) (*models.Product, error) {
+	product, err := uc.ProductService.AdjustStock(ctx, productID, -quantity, models.StockReasonReservation)
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (uc *ProductUseCase) ReleaseStock(ctx context.Context, productID int, quantity int) (*models.Product, error) {
+	product, err := uc.ProductService.AdjustStock(ctx, productID, quantity, models.StockReasonRelease)
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func validateInventoryPolicy(product *models.Product) error {
+	switch product.InventoryPolicy {
+	case "":
+		product.InventoryPolicy = models.InventoryPolicyDeny
+	case models.InventoryPolicyDeny, models.InventoryPolicyPreorder:
+	case models.InventoryPolicyBackorder:
+		if product.BackorderLimit <= 0 {
+			return fmt.Errorf("%w: backorder_limit must be greater than zero", models.ErrInvalidStockPolicy)
+		}
+	default:
+		return fmt.Errorf("%w: %s", models.ErrInvalidStockPolicy, product.InventoryPolicy)
+	}
+
+	if product.InventoryPolicy != models.InventoryPolicyBackorder {
+		product.BackorderLimit = 0
+	}
+	if product.InventoryPolicy != models.InventoryPolicyPreorder {
+		product.PreorderAvailableAt = nil
+	}
+	return nil
+}
+
+func (uc *ProductUseCase) ReconcileInventory(ctx context.Context, countFile io.Reader, apply bool) (*models.StockReconciliation, error) {
+	counts, err := parseStockCountFile(countFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := uc.ProductService.ReconcileStock(ctx, counts, apply)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"count_rows": len(counts),
+			"apply":      apply,
+		}).Errorf("uc.ProductService.ReconcileStock got error %v", err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseStockCountFile reads "product id, counted quantity" rows from a warehouse count file. A header row is
+// optional and counts listed more than once for the same product are summed.
+func parseStockCountFile(countFile io.Reader) ([]models.StockCount, error) {
+	reader := csv.NewReader(countFile)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid count file: %w", err)
+	}
+
+	var counts []models.StockCount
+	indexById := make(map[int]int)
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("invalid count file: line %d must contain product id and counted quantity", i+1)
+		}
+
+		productId, err := strconv.Atoi(strings.TrimSpace(row[0]))
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("invalid count file: line %d has invalid product id %q", i+1, row[0])
+		}
+
+		quantity, err := strconv.Atoi(strings.TrimSpace(row[1]))
+		if err != nil || quantity < 0 {
+			return nil, fmt.Errorf("invalid count file: line %d has invalid counted quantity %q", i+1, row[1])
+		}
+
+		if idx, ok := indexById[productId]; ok {
+			counts[idx].CountedQuantity += quantity
+			continue
+		}
+		indexById[productId] = len(counts)
+		counts = append(counts, models.StockCount{
+			ProductID:       productId,
+			CountedQuantity: quantity,
+		})
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("invalid count file: no count rows found")
+	}
+	return counts, nil
+}
+
+func (uc *ProductUseCase) CaptureInventorySnapshot(ctx context.Context, snapshotDate time.Time) (int64, error) {
+	captured, err := uc.ProductService.CaptureInventorySnapshot(ctx, snapshotDate)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"snapshot_date": snapshotDate.Format(time.DateOnly),
+		}).Errorf("uc.ProductService.CaptureInventorySnapshot got error %v", err)
+		return 0, err
+	}
+
+	return captured, nil
+}
+
+func (uc *ProductUseCase) GetInventorySnapshot(ctx context.Context, snapshotDate time.Time) ([]models.InventorySnapshot, error) {
+	snapshots, err := uc.ProductService.GetInventorySnapshot(ctx, snapshotDate)
+	if err != nil {
+		return []models.InventorySnapshot{}, err
+	}
+
+	return snapshots, nil
+}
+
+func (uc *ProductUseCase) GetInventoryValuation(ctx context.Context, snapshotDate *time.Time) (*models.InventoryValuationReport, error) {
+	report, err := uc.ProductService.GetInventoryValuation(ctx, snapshotDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (uc *ProductUseCase) ReceiveLot(ctx context.Context, lot *models.ProductLot) (*models.Product, error) {
+	if lot.IsExpired(time.Now()) {
+		return nil, fmt.Errorf("lot %s is already expired", lot.LotNumber)
+	}
+
+	product, err := uc.ProductService.ReceiveLot(ctx, lot)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"product_id": lot.ProductID,
+			"lot_number": lot.LotNumber,
+		}).Errorf("uc.ProductService.ReceiveLot got error %v", err)
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (uc *ProductUseCase) WriteOffLot(ctx context.Context, lotID int) (*models.Product, error) {
+	product, err := uc.ProductService.WriteOffLot(ctx, lotID)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"lot_id": lotID,
+		}).Errorf("uc.ProductService.WriteOffLot got error %v", err)
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (uc *ProductUseCase) GetExpiringLots(ctx context.Context, days int) ([]models.ExpiringLot, error) {
+	lots, err := uc.ProductService.GetExpiringLots(ctx, time.Now().AddDate(0, 0, days))
+	if err != nil {
+		return []models.ExpiringLot{}, err
+	}
+
+	return lots, nil
+}
+
+func (uc *ProductUseCase) GetAuditEntries(ctx context.Context, filter *models.AuditFilter) ([]models.AuditEntry, int64, error) {
+	entries, total, err := uc.ProductService.GetAuditEntries(ctx, filter)
+	if err != nil {
+		log.Logger.WithFields(logrus.Fields{
+			"filter": filter,
+		}).Errorf("uc.ProductService.GetAuditEntries got error %v", err)
+		return []models.AuditEntry{}, 0, err
 	}
 
-	return products, total, nil
+	return entries, total, nil
 }
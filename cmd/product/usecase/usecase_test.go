@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"product_commerce/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStockCountFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []models.StockCount
+		wantErr bool
+	}{
+		{
+			name: "rows without header",
+			file: "1,10\n2,0\n",
+			want: []models.StockCount{{ProductID: 1, CountedQuantity: 10}, {ProductID: 2, CountedQuantity: 0}},
+		},
+		{
+			name: "header row is skipped",
+			file: "product_id,counted_quantity\n7,3\n",
+			want: []models.StockCount{{ProductID: 7, CountedQuantity: 3}},
+		},
+		{
+			name: "spaces and extra columns are ignored",
+			file: " 4 , 5 ,shelf A\n",
+			want: []models.StockCount{{ProductID: 4, CountedQuantity: 5}},
+		},
+		{
+			name: "counts of the same product are summed in first-seen order",
+			file: "2,1\n1,4\n2,6\n",
+			want: []models.StockCount{{ProductID: 2, CountedQuantity: 7}, {ProductID: 1, CountedQuantity: 4}},
+		},
+		{
+			name:    "invalid product id after the first row",
+			file:    "1,1\nabc,2\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative quantity",
+			file:    "1,-3\n",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric quantity",
+			file:    "1,many\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing quantity",
+			file:    "1\n",
+			wantErr: true,
+		},
+		{
+			name:    "header only",
+			file:    "product_id,counted_quantity\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty file",
+			file:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStockCountFile(strings.NewReader(tt.file))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseStockCountFile() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStockCountFile() got error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStockCountFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
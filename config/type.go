@@ -1,9 +1,15 @@
 package config
 
+import "time"
+
 type Config struct {
-	App      AppConfig      `yaml:"app" validate:"required"`
-	Database DatabaseConfig `yaml:"database" validate:"required"`
-	Redis    RedisConfig    `yaml:"redis" validate:"required"`
+	App       AppConfig       `yaml:"app" validate:"required"`
+	Database  DatabaseConfig  `yaml:"database" validate:"required"`
+	Redis     RedisConfig     `yaml:"redis" validate:"required"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Inventory InventoryConfig `yaml:"inventory"`
+	Search    SearchConfig    `yaml:"search"`
+	Seed      SeedConfig      `yaml:"seed"`
 }
 
 type AppConfig struct {
@@ -11,11 +17,45 @@ type AppConfig struct {
 }
 
 type DatabaseConfig struct {
+	// "postgres" (default) or "sqlite", for which Name is the database file or ":memory:"
+	Driver   string `yaml:"driver"`
 	Host     string `yaml:"host" validate:"required"`
 	User     string `yaml:"user" validate:"required"`
 	Password string `yaml:"password" validate:"required"`
 	Name     string `yaml:"name" validate:"required"`
 	Port     string `yaml:"port" validate:"required"`
+	// refuse to start while embedded migrations are not applied
+	RequireMigrated bool `yaml:"require_migrated" mapstructure:"require_migrated"`
+	// apply pending migrations before serving, e.g. for an in-memory sqlite database
+	MigrateOnStartup bool          `yaml:"migrate_on_startup" mapstructure:"migrate_on_startup"`
+	Replicas         ReplicaConfig `yaml:"replicas"`
+	Pool             PoolConfig    `yaml:"pool"`
+	// longest a single statement may run before the server cancels it, 0 leaves the server default. Migrations
+	// are not limited.
+	StatementTimeout   time.Duration `yaml:"statement_timeout" mapstructure:"statement_timeout"`
+	LogLevel           string        `yaml:"log_level" mapstructure:"log_level"`                       // e.g., "silent", "error", "warn", "info"
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" mapstructure:"slow_query_threshold"` // 0 disables slow-query logging
+}
+
+// PoolConfig sizes the connection pool of the primary and of each replica. A zero value keeps the driver default.
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns" mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" mapstructure:"conn_max_idle_time"`
+}
+
+// ReplicaConfig lists the read replicas, by DSN, that serve product and category reads and searches.
+type ReplicaConfig struct {
+	DSNs                []string      `yaml:"dsns" mapstructure:"dsns"`
+	MaxLag              time.Duration `yaml:"max_lag" mapstructure:"max_lag"` // replicas further behind are skipped
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" mapstructure:"health_check_interval"`
+	// how long a client reads from the primary after it wrote. The time of the last write is carried by the
+	// client in the last_write cookie or the X-Last-Write header; a client that drops both only reads its own
+	// writes when its reads land on the instance that wrote, which a load balancer does not guarantee without
+	// sticky routing. Instances compare the time against their own clocks, so keep the window well above
+	// their clock skew.
+	ReadYourWritesWindow time.Duration `yaml:"read_your_writes_window" mapstructure:"read_your_writes_window"`
 }
 
 type RedisConfig struct {
@@ -23,3 +63,49 @@ type RedisConfig struct {
 	Port     string `yaml:"port" validate:"required"`
 	Password string `yaml:"password" validate:"required"`
 }
+
+type CacheConfig struct {
+	Driver    string         `yaml:"driver"` // "redis" (default) or "memory", which is not shared between instances
+	Codec     string         `yaml:"codec"`  // e.g., "json", "msgpack"
+	TTL       CacheTTLConfig `yaml:"ttl"`
+	LocalSize int            `yaml:"local_size" mapstructure:"local_size"` // entries per entity kept in memory, 0 disables
+	LocalTTL  time.Duration  `yaml:"local_ttl" mapstructure:"local_ttl"`
+	Warmup    WarmupConfig   `yaml:"warmup"`
+	// how often invalidations that failed after a commit are retried
+	InvalidationRetryInterval time.Duration `yaml:"invalidation_retry_interval" mapstructure:"invalidation_retry_interval"`
+}
+
+// CacheTTLConfig holds the Redis ttl of each cached entity. An empty ttl keeps the default.
+type CacheTTLConfig struct {
+	Product         time.Duration `yaml:"product"`
+	ProductStale    time.Duration `yaml:"product_stale" mapstructure:"product_stale"` // served while refreshing after Product
+	ProductNotFound time.Duration `yaml:"product_not_found" mapstructure:"product_not_found"`
+	ProductCat      time.Duration `yaml:"product_category" mapstructure:"product_category"`
+	Search          time.Duration `yaml:"search"`
+}
+
+type WarmupConfig struct {
+	OnStartup        bool    `yaml:"on_startup" mapstructure:"on_startup"`
+	TopN             int     `yaml:"top_n" mapstructure:"top_n"`
+	Criteria         string  `yaml:"criteria"` // e.g., "reserved", "newest", "stock", "price"
+	BatchSize        int     `yaml:"batch_size" mapstructure:"batch_size"`
+	BatchesPerSecond float64 `yaml:"batches_per_second" mapstructure:"batches_per_second"` // 0 disables the limit
+}
+
+type InventoryConfig struct {
+	SnapshotTime string `yaml:"snapshot_time" mapstructure:"snapshot_time"` // daily capture time, e.g. "23:55"
+}
+
+type SearchConfig struct {
+	// trigram similarity between 0 and 1 a fuzzy name match needs, 0 keeps the default
+	FuzzyThreshold float64 `yaml:"fuzzy_threshold" mapstructure:"fuzzy_threshold"`
+}
+
+// SeedConfig sizes the synthetic catalog generated by the seed command. The same seed always generates the
+// same catalog.
+type SeedConfig struct {
+	Categories int   `yaml:"categories"`
+	Products   int   `yaml:"products"`
+	Seed       int64 `yaml:"seed"`
+	BatchSize  int   `yaml:"batch_size" mapstructure:"batch_size"`
+}
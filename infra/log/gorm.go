@@ -0,0 +1,96 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"time"
+)
+
+// GormLogger writes GORM's SQL log through Logger. Failed queries are logged at the error level and queries
+// slower than SlowThreshold at the warn level, both with the request id carried by the context.
+type GormLogger struct {
+	Level         logger.LogLevel
+	SlowThreshold time.Duration // 0 disables slow-query logging
+}
+
+func NewGormLogger(level logger.LogLevel, slowThreshold time.Duration) *GormLogger {
+	return &GormLogger{
+		Level:         level,
+		SlowThreshold: slowThreshold,
+	}
+}
+
+// ParseGormLogLevel reads one of silent, error, warn or info. An empty level means warn.
+func ParseGormLogLevel(level string) (logger.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "silent":
+		return logger.Silent, nil
+	case "error":
+		return logger.Error, nil
+	case "", "warn":
+		return logger.Warn, nil
+	case "info":
+		return logger.Info, nil
+	default:
+		return 0, fmt.Errorf("unknown database log level %q, expected silent, error, warn or info", level)
+	}
+}
+
+func (l *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.Level = level
+	return &copied
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= logger.Info {
+		l.entry(ctx).Infof(msg, args...)
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= logger.Warn {
+		l.entry(ctx).Warnf(msg, args...)
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= logger.Error {
+		l.entry(ctx).Errorf(msg, args...)
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.Level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	slow := l.SlowThreshold > 0 && elapsed > l.SlowThreshold
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.Level >= logger.Error:
+		l.traceEntry(ctx, elapsed, fc).Errorf("query got error %v", err)
+	case slow && l.Level >= logger.Warn:
+		l.traceEntry(ctx, elapsed, fc).Warnf("slow query, over %s", l.SlowThreshold)
+	case l.Level >= logger.Info:
+		l.traceEntry(ctx, elapsed, fc).Info("query")
+	}
+}
+
+func (l *GormLogger) entry(ctx context.Context) *logrus.Entry {
+	return Logger.WithField("request_id", ctx.Value("request_id"))
+}
+
+func (l *GormLogger) traceEntry(ctx context.Context, elapsed time.Duration, fc func() (string, int64)) *logrus.Entry {
+	sql, rows := fc()
+	return l.entry(ctx).WithFields(logrus.Fields{
+		"sql":     sql,
+		"rows":    rows,
+		"elapsed": elapsed,
+	})
+}
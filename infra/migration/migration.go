@@ -0,0 +1,323 @@
+package migration
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Every database driver has its own set of migrations under sql/<driver>, with the same versions.
+//
+//go:embed sql/postgres/*.sql sql/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockId serializes migrations run by several instances at once.
+const migrationLockId = 5_310_041
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change, read from sql/<driver>/<version>_<name>.up.sql and its .down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator applies the embedded migrations and records them in the schema_migrations table. Each migration runs
+// in its own transaction together with its record, so a failed migration leaves no trace.
+type Migrator struct {
+	Database   *gorm.DB
+	Migrations []Migration
+}
+
+// NewMigrator loads the migrations written for the driver db was opened with.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, path.Join("sql", db.Dialector.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		Database:   db,
+		Migrations: migrations,
+	}, nil
+}
+
+// Status lists every known migration with the time it was applied, nil when it is pending.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet, in order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.To(ctx, m.LatestVersion())
+}
+
+// Down reverts the given number of most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(m.Migrations) - 1; i >= 0 && reverted < steps; i-- {
+		if _, ok := applied[m.Migrations[i].Version]; !ok {
+			continue
+		}
+
+		err = m.revert(ctx, m.Migrations[i])
+		if err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// To moves the schema to the given version: pending migrations up to it are applied and applied migrations
+// above it are reverted. Version 0 reverts everything.
+func (m *Migrator) To(ctx context.Context, version int64) (int, error) {
+	if version != 0 && m.find(version) == nil {
+		return 0, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+			continue
+		}
+
+		err = m.revert(ctx, migration)
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > version {
+			continue
+		}
+
+		err = m.apply(ctx, migration)
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func (m *Migrator) LatestVersion() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	return m.Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		done, err := lockAndCheck(tx, migration.Version)
+		if err != nil || done {
+			return err
+		}
+
+		err = tx.Exec(migration.Up).Error
+		if err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+
+		return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	return m.Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		done, err := lockAndCheck(tx, migration.Version)
+		if err != nil || !done {
+			return err
+		}
+
+		err = tx.Exec(migration.Down).Error
+		if err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+
+		return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+	})
+}
+
+// lockAndCheck waits for any other migrator and then reports whether the version is applied, which another
+// instance may have done in the meantime.
+func lockAndCheck(tx *gorm.DB, version int64) (bool, error) {
+	// SQLite lets only one transaction write at a time anyway
+	if tx.Dialector.Name() == "postgres" {
+		// the statement timeout of the connection is meant for requests; waiting for another migrator and
+		// rewriting large tables may take much longer
+		err := tx.Exec("SET LOCAL statement_timeout = 0").Error
+		if err != nil {
+			return false, err
+		}
+
+		err = tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockId).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var count int64
+	err := tx.Table("schema_migrations").Where("version = ?", version).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]appliedMigration, error) {
+	db := m.Database.WithContext(ctx)
+	// SQLite reads back only TIMESTAMP columns as times
+	timestampType := "TIMESTAMPTZ"
+	if db.Dialector.Name() != "postgres" {
+		timestampType = "TIMESTAMP"
+	}
+	err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    BIGINT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at ` + timestampType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var records []appliedMigration
+	err = db.Table("schema_migrations").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.Migrations {
+		if m.Migrations[i].Version == version {
+			return &m.Migrations[i]
+		}
+	}
+	return nil
+}
+
+// loadMigrations pairs the up and down files of every version in dir and sorts them by version.
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
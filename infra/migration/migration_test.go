@@ -0,0 +1,84 @@
+package migration
+
+import (
+	"path"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/0002_add_index.up.sql":       {Data: []byte("CREATE INDEX a ON b (c);")},
+		"sql/0002_add_index.down.sql":     {Data: []byte("DROP INDEX a;")},
+		"sql/0001_create_table.up.sql":    {Data: []byte("CREATE TABLE b (c INTEGER);")},
+		"sql/0001_create_table.down.sql":  {Data: []byte("DROP TABLE b;")},
+		"sql/0010_later_version.up.sql":   {Data: []byte("SELECT 1;")},
+		"sql/0010_later_version.down.sql": {Data: []byte("SELECT 2;")},
+	}
+
+	migrations, err := loadMigrations(files, "sql")
+	if err != nil {
+		t.Fatalf("loadMigrations() got error %v", err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "create_table", Up: "CREATE TABLE b (c INTEGER);", Down: "DROP TABLE b;"},
+		{Version: 2, Name: "add_index", Up: "CREATE INDEX a ON b (c);", Down: "DROP INDEX a;"},
+		{Version: 10, Name: "later_version", Up: "SELECT 1;", Down: "SELECT 2;"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("loadMigrations() returned %d migrations, want %d", len(migrations), len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsInvalidSets(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down file": {
+			"sql/0001_create_table.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"two names for one version": {
+			"sql/0001_create_table.up.sql":   {Data: []byte("SELECT 1;")},
+			"sql/0001_other_name.down.sql":   {Data: []byte("SELECT 2;")},
+			"sql/0001_create_table.down.sql": {Data: []byte("SELECT 2;")},
+		},
+		"unexpected file name": {
+			"sql/create_table.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadMigrations(files, "sql")
+			if err == nil {
+				t.Errorf("loadMigrations() got no error")
+			}
+		})
+	}
+}
+
+// Every driver must ship the same versions, or a schema check would pass on one driver and fail on another.
+func TestEmbeddedMigrationsMatchAcrossDrivers(t *testing.T) {
+	postgres, err := loadMigrations(migrationFiles, path.Join("sql", "postgres"))
+	if err != nil {
+		t.Fatalf("loading postgres migrations got error %v", err)
+	}
+	sqlite, err := loadMigrations(migrationFiles, path.Join("sql", "sqlite"))
+	if err != nil {
+		t.Fatalf("loading sqlite migrations got error %v", err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations and sqlite %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d is %d_%s on postgres and %d_%s on sqlite",
+				i, postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+-- The catalog tables predate the migrations, so existing databases already have them.
+CREATE TABLE IF NOT EXISTS product_category (
+    id   SERIAL PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS product (
+    id          SERIAL PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    stock       INTEGER NOT NULL DEFAULT 0,
+    category_id INTEGER NOT NULL REFERENCES product_category (id),
+    price       NUMERIC(12, 2) NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS product_category_id_idx ON product (category_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS stock_adjustment;
+
+ALTER TABLE product
+    DROP COLUMN IF EXISTS preorder_available_at,
+    DROP COLUMN IF EXISTS backorder_limit,
+    DROP COLUMN IF EXISTS inventory_policy,
+    DROP COLUMN IF EXISTS cost;
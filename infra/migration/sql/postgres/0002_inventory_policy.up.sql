@@ -0,0 +1,15 @@
+ALTER TABLE product
+    ADD COLUMN IF NOT EXISTS cost NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS inventory_policy TEXT NOT NULL DEFAULT 'deny',
+    ADD COLUMN IF NOT EXISTS backorder_limit INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS preorder_available_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS stock_adjustment (
+    id         SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+    quantity   INTEGER NOT NULL,
+    reason     TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS stock_adjustment_product_id_created_at_idx ON stock_adjustment (product_id, created_at);
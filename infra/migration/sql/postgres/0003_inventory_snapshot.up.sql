@@ -0,0 +1,9 @@
+-- Snapshots outlive the products they describe, so product_id has no foreign key.
+CREATE TABLE IF NOT EXISTS inventory_snapshot (
+    snapshot_date DATE NOT NULL,
+    product_id    INTEGER NOT NULL,
+    category_id   INTEGER NOT NULL,
+    stock         INTEGER NOT NULL,
+    unit_cost     NUMERIC(12, 2) NOT NULL,
+    UNIQUE (snapshot_date, product_id)
+);
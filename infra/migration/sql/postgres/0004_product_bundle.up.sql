@@ -0,0 +1,10 @@
+ALTER TABLE product ADD COLUMN IF NOT EXISTS is_bundle BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS product_bundle_component (
+    bundle_id    INTEGER NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+    component_id INTEGER NOT NULL REFERENCES product (id),
+    quantity     INTEGER NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (bundle_id, component_id)
+);
+
+CREATE INDEX IF NOT EXISTS product_bundle_component_component_id_idx ON product_bundle_component (component_id);
@@ -0,0 +1,12 @@
+ALTER TABLE product ADD COLUMN IF NOT EXISTS is_lot_tracked BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS product_lot (
+    id         SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+    lot_number TEXT NOT NULL,
+    quantity   INTEGER NOT NULL CHECK (quantity >= 0),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS product_lot_product_id_expires_at_idx ON product_lot (product_id, expires_at);
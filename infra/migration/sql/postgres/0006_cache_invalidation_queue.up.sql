@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS cache_invalidation_queue (
+    id              BIGSERIAL PRIMARY KEY,
+    entity          TEXT NOT NULL,
+    entity_id       INTEGER NOT NULL DEFAULT 0,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS cache_invalidation_queue_next_attempt_at_idx ON cache_invalidation_queue (next_attempt_at);
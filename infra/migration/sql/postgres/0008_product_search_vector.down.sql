@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS product_search_vector_idx;
+
+ALTER TABLE product DROP COLUMN IF EXISTS search_vector;
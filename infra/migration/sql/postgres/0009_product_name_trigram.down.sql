@@ -0,0 +1 @@
+DROP INDEX IF EXISTS product_name_trgm_idx;
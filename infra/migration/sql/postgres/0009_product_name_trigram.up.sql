@@ -0,0 +1,4 @@
+-- Trigram index behind typo-tolerant name search and did-you-mean suggestions.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS product_name_trgm_idx ON product USING GIN (name gin_trgm_ops);
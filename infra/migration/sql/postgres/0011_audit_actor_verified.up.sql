@@ -0,0 +1,2 @@
+-- Actors are taken from the X-Actor header, which nothing checks yet; entries say whether theirs was verified.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS actor_verified BOOLEAN NOT NULL DEFAULT FALSE;
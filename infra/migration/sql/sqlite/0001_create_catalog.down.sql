@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS product;
+DROP TABLE IF EXISTS product_category;
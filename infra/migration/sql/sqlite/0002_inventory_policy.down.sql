@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS stock_adjustment;
+
+ALTER TABLE product DROP COLUMN preorder_available_at;
+ALTER TABLE product DROP COLUMN backorder_limit;
+ALTER TABLE product DROP COLUMN inventory_policy;
+ALTER TABLE product DROP COLUMN cost;
@@ -0,0 +1,15 @@
+-- SQLite adds one column per statement.
+ALTER TABLE product ADD COLUMN cost NUMERIC(12, 2) NOT NULL DEFAULT 0;
+ALTER TABLE product ADD COLUMN inventory_policy TEXT NOT NULL DEFAULT 'deny';
+ALTER TABLE product ADD COLUMN backorder_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE product ADD COLUMN preorder_available_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS stock_adjustment (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id INTEGER NOT NULL REFERENCES product (id) ON DELETE CASCADE,
+    quantity   INTEGER NOT NULL,
+    reason     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stock_adjustment_product_id_created_at_idx ON stock_adjustment (product_id, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS product_bundle_component;
+
+ALTER TABLE product DROP COLUMN is_bundle;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS product_lot;
+
+ALTER TABLE product DROP COLUMN is_lot_tracked;
@@ -0,0 +1 @@
+ALTER TABLE product DROP COLUMN version;
@@ -0,0 +1,2 @@
+-- SQLite has no full-text search column, full-text queries match the name and description as substrings.
+SELECT 1;
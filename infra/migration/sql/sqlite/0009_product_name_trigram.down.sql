@@ -0,0 +1,2 @@
+-- SQLite has no trigram matching, fuzzy name queries match the name as a substring.
+SELECT 1;
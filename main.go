@@ -1,37 +1,134 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"os"
 	"product_commerce/cmd/product/handler"
+	"product_commerce/cmd/product/job"
 	"product_commerce/cmd/product/repository"
 	"product_commerce/cmd/product/resource"
 	"product_commerce/cmd/product/service"
 	"product_commerce/cmd/product/usecase"
 	"product_commerce/config"
 	"product_commerce/infra/log"
+	"product_commerce/infra/migration"
 	"product_commerce/routes"
+	"time"
 )
 
 func main() {
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "warmup":
+		warmup(args)
+	case "migrate":
+		migrate(args)
+	case "seed":
+		seed(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected serve, warmup, migrate or seed\n", command)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 	cfg := config.LoadConfig()
-	redis := resource.InitRedis(&cfg)
-	db := resource.InitDB(&cfg)
 
-	// logger
+	// logger, set up first since the database logs through it
 	log.SetupLogger()
 
+	db := resource.InitDB(&cfg)
+
+	migrator, err := migration.NewMigrator(db)
+	if err != nil {
+		log.Logger.Fatalf("failed to load migrations: %v", err)
+	}
+	checkSchema(&cfg, migrator)
+
 	// prepare each layer
-	productRepository := repository.NewProductRepo(db, redis)
-	productService := service.NewProductService(*productRepository)
+	databases := map[string]*sql.DB{"primary": sqlDB(db)}
+	var replicaSet *repository.ReplicaSet
+	var replicaLag time.Duration
+	if replicas := resource.InitReplicas(&cfg); len(replicas) > 0 {
+		for i, replica := range replicas {
+			databases[fmt.Sprintf("replica-%d", i)] = sqlDB(replica)
+		}
+
+		replicaCfg := cfg.Database.Replicas
+		replicaSet = repository.NewReplicaSet(replicas, replicaCfg.MaxLag, replicaCfg.HealthCheckInterval, replicaCfg.ReadYourWritesWindow)
+		replicaLag = replicaSet.MaxLag()
+		go replicaSet.Start(context.Background())
+	}
+
+	productRepository := repository.NewProductRepo(db, replicaSet, cfg.Search.FuzzyThreshold)
+	var productCache repository.ProductCache
+	switch cfg.Cache.Driver {
+	case resource.CacheDriverMemory:
+		productCache = repository.NewMemoryCache(cfg.Cache.TTL)
+	case "", resource.CacheDriverRedis:
+		redis := resource.InitRedis(&cfg)
+		productCache = newRedisCache(&cfg, redis)
+		if cfg.Cache.LocalSize > 0 {
+			tieredCache := repository.NewTieredCache(productCache, redis, cfg.Cache.LocalSize, cfg.Cache.LocalTTL)
+			go tieredCache.Subscribe(context.Background())
+			productCache = tieredCache
+		}
+	default:
+		log.Logger.Fatalf("unknown cache driver %q, expected redis or memory", cfg.Cache.Driver)
+	}
+	productStore := repository.NewCachedProductStore(productRepository, productCache, replicaLag)
+	productService := service.NewProductService(productStore)
 	productUseCase := usecase.NewProductUseCase(*productService)
 	productHandler := handler.NewProductHandler(*productUseCase)
+	monitoringHandler := handler.NewMonitoringHandler(databases)
+
+	// scheduled jobs
+	go job.NewInventorySnapshotJob(*productUseCase, cfg.Inventory.SnapshotTime).Start(context.Background())
+	go job.NewCacheInvalidationRetryJob(productStore, cfg.Cache.InvalidationRetryInterval).Start(context.Background())
+	if cfg.Cache.Warmup.OnStartup {
+		go func() {
+			_, err := job.NewCacheWarmupJob(productRepository, productCache, cfg.Cache.Warmup).Run(context.Background())
+			if err != nil {
+				log.Logger.Errorf("cache warm-up on startup failed: %v", err)
+			}
+		}()
+	}
 
 	port := cfg.App.Port
 	router := gin.Default()
 
 	// routes
-	routes.SetupRoutes(router, *productHandler)
+	routes.SetupRoutes(router, *productHandler, *monitoringHandler)
 
 	_ = router.Run(":" + port)
-	log.Logger.Info("Server Running on Port: %s", port)
+	log.Logger.Infof("Server Running on Port: %s", port)
+}
+
+func newRedisCache(cfg *config.Config, redis *goredis.Client) *repository.RedisCache {
+	codec, err := repository.NewCodec(cfg.Cache.Codec)
+	if err != nil {
+		log.Logger.Fatalf("failed to set up cache: %v", err)
+	}
+
+	return repository.NewRedisCache(redis, codec, cfg.Cache.TTL)
+}
+
+func sqlDB(db *gorm.DB) *sql.DB {
+	database, err := db.DB()
+	if err != nil {
+		log.Logger.Fatalf("failed to get connection pool: %v", err)
+	}
+	return database
 }
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lastWriteCookie = "last_write"
+	lastWriteHeader = "X-Last-Write"
+
+	// instances stamp the time with their own clocks, so a time slightly ahead of this one is still honest
+	lastWriteClockSkew = time.Second
+)
+
+// ReadYourWrites lets the client carry the time of its last write, so whichever instance serves its next request
+// reads from the primary while replicas may not have caught up yet. The time is handed out only by responses to
+// requests that committed a write, as a cookie for browsers and as a header for clients that echo it
+// themselves. Must run after RequestLogger, which sets up the request context.
+func ReadYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if lastWrite, ok := parseLastWrite(c); ok {
+			ctx = context.WithValue(ctx, "last_write", lastWrite)
+		}
+
+		// stored by the repository once a write of this request has committed
+		lastCommit := new(atomic.Int64)
+		ctx = context.WithValue(ctx, "last_commit", lastCommit)
+		c.Request = c.Request.WithContext(ctx)
+		c.Writer = &lastWriteWriter{ResponseWriter: c.Writer, lastCommit: lastCommit}
+
+		c.Next()
+	}
+}
+
+// parseLastWrite reads the time of the client's last write. Times too far ahead are ignored, as they would keep
+// the client on the primary for good, and times a little ahead are taken as now.
+func parseLastWrite(c *gin.Context) (time.Time, bool) {
+	value := c.GetHeader(lastWriteHeader)
+	if value == "" {
+		value, _ = c.Cookie(lastWriteCookie)
+	}
+
+	writtenAtMs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	writtenAt := time.UnixMilli(writtenAtMs)
+	if writtenAt.After(now.Add(lastWriteClockSkew)) {
+		return time.Time{}, false
+	}
+	if writtenAt.After(now) {
+		writtenAt = now
+	}
+	return writtenAt, true
+}
+
+// lastWriteWriter adds the time of the last committed write to the response just before its headers are sent.
+type lastWriteWriter struct {
+	gin.ResponseWriter
+	lastCommit *atomic.Int64
+}
+
+func (w *lastWriteWriter) WriteHeaderNow() {
+	w.stamp()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *lastWriteWriter) Write(data []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *lastWriteWriter) WriteString(s string) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *lastWriteWriter) stamp() {
+	committedAtMs := w.lastCommit.Load()
+	if committedAtMs == 0 || w.Written() {
+		return
+	}
+
+	committedAt := strconv.FormatInt(committedAtMs, 10)
+	w.Header().Set(lastWriteHeader, committedAt)
+	http.SetCookie(w, &http.Cookie{
+		Name:     lastWriteCookie,
+		Value:    committedAt,
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ReadYourWrites())
+	router.POST("/", handler)
+	return router
+}
+
+func TestReadYourWritesStampsCommittedWritesOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit bool
+		status int
+	}{
+		{name: "committed write", commit: true, status: http.StatusCreated},
+		{name: "committed write with a failing response", commit: true, status: http.StatusInternalServerError},
+		{name: "read-only request", commit: false, status: http.StatusOK},
+		{name: "rejected request", commit: false, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(func(c *gin.Context) {
+				if tt.commit {
+					c.Request.Context().Value("last_commit").(*atomic.Int64).Store(time.Now().UnixMilli())
+				}
+				c.JSON(tt.status, gin.H{})
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			stamped := recorder.Header().Get(lastWriteHeader) != ""
+			if stamped != tt.commit {
+				t.Errorf("response stamped with the last write = %t, want %t", stamped, tt.commit)
+			}
+			if cookie := recorder.Header().Get("Set-Cookie"); (cookie != "") != tt.commit {
+				t.Errorf("response cookie %q, want one only after a commit", cookie)
+			}
+		})
+	}
+}
+
+func TestReadYourWritesLastWrite(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		lastWrite string
+		want      bool
+	}{
+		{name: "recent write", lastWrite: strconv.FormatInt(now.Add(-time.Second).UnixMilli(), 10), want: true},
+		{name: "slightly ahead", lastWrite: strconv.FormatInt(now.Add(lastWriteClockSkew/2).UnixMilli(), 10), want: true},
+		{name: "far in the future", lastWrite: strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10), want: false},
+		{name: "malformed", lastWrite: "yesterday", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastWrite time.Time
+			var ok bool
+			router := newTestRouter(func(c *gin.Context) {
+				lastWrite, ok = c.Request.Context().Value("last_write").(time.Time)
+				c.Status(http.StatusOK)
+			})
+
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request.Header.Set(lastWriteHeader, tt.lastWrite)
+			router.ServeHTTP(httptest.NewRecorder(), request)
+
+			if ok != tt.want {
+				t.Fatalf("last write taken from %q = %t, want %t", tt.lastWrite, ok, tt.want)
+			}
+			if ok && lastWrite.After(time.Now()) {
+				t.Errorf("last write %s is ahead of now", lastWrite)
+			}
+		})
+	}
+}
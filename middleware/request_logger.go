@@ -16,6 +16,14 @@ func RequestLogger() gin.HandlerFunc {
 		defer cancel()
 
 		ctx := context.WithValue(timeoutCtx, "request_id", requestID)
+		// the caller's identity, recorded in the audit log of the changes it makes. Nothing authenticates the
+		// header yet, so the actor is recorded as unverified.
+		actor := c.GetHeader("X-Actor")
+		if actor == "" {
+			actor = "anonymous"
+		}
+		ctx = context.WithValue(ctx, "actor", actor)
+		ctx = context.WithValue(ctx, "actor_verified", false)
 		c.Request = c.Request.WithContext(ctx)
 
 		startTime := time.Now()
@@ -24,6 +32,7 @@ func RequestLogger() gin.HandlerFunc {
 
 		requestLog := logrus.Fields{
 			"request_id": requestID,
+			"actor":      actor,
 			"method":     c.Request.Method,
 			"path":       c.Request.URL.Path,
 			"status":     c.Writer.Status(),
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"product_commerce/cmd/product/resource"
+	"product_commerce/config"
+	"product_commerce/infra/log"
+	"product_commerce/infra/migration"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: migrate up | down [-steps n] | status | to <version>"
+
+// migrate runs the embedded schema migrations against the configured database.
+func migrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig()
+	log.SetupLogger()
+	db := resource.InitDB(&cfg)
+
+	migrator, err := migration.NewMigrator(db)
+	if err != nil {
+		log.Logger.Fatalf("failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	var changed int
+	switch args[0] {
+	case "up":
+		changed, err = migrator.Up(ctx)
+
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		_ = flags.Parse(args[1:])
+		changed, err = migrator.Down(ctx, *steps)
+
+	case "to":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		version, errParse := strconv.ParseInt(args[1], 10, 64)
+		if errParse != nil {
+			log.Logger.Fatalf("invalid migration version %q: %v", args[1], errParse)
+		}
+		changed, err = migrator.To(ctx, version)
+
+	case "status":
+		statuses, errStatus := migrator.Status(ctx)
+		if errStatus != nil {
+			log.Logger.Fatalf("failed to read migration status: %v", errStatus)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %s\n", status.Version, status.Name, appliedAt)
+		}
+		return
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Logger.Fatalf("migrate %s failed after %d migrations: %v", args[0], changed, err)
+	}
+	log.Logger.Infof("migrate %s: %d migrations applied or reverted", args[0], changed)
+}
+
+// checkSchema refuses to serve on a schema that is missing migrations this build depends on. With
+// migrate_on_startup the pending migrations are applied first, which an in-memory database always needs.
+func checkSchema(cfg *config.Config, migrator *migration.Migrator) {
+	if cfg.Database.MigrateOnStartup {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			log.Logger.Fatalf("migrate up on startup failed after %d migrations: %v", applied, err)
+		}
+		log.Logger.Infof("migrate up on startup: %d migrations applied", applied)
+	}
+
+	if !cfg.Database.RequireMigrated {
+		return
+	}
+
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		log.Logger.Fatalf("failed to read migration status: %v", err)
+	}
+	if len(pending) > 0 {
+		log.Logger.Fatalf("database schema is behind: %d pending migrations starting at %04d_%s, run the migrate up command first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Audited entities and actions.
+const (
+	AuditEntityProduct    = "product"
+	AuditEntityProductCat = "product_category"
+
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	// actor of changes made outside of a request, e.g. by a job or a command
+	AuditActorSystem = "system"
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// AuditEntry records one change of a catalog entity, written in the same transaction as the change.
+// ActorVerified is false when the actor is only what the client claimed to be, as with the X-Actor header.
+type AuditEntry struct {
+	ID            int64        `json:"id"`
+	Entity        string       `json:"entity"`
+	EntityID      int          `json:"entity_id"`
+	Action        string       `json:"action"`
+	Actor         string       `json:"actor"`
+	ActorVerified bool         `json:"actor_verified"`
+	RequestID     string       `json:"request_id"`
+	Changes       AuditChanges `json:"changes"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// FieldChange is the value of one field before and after a change. Before is nil for a created entity and After
+// is nil for a deleted one.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditChanges holds the changed fields by their JSON name. It is stored as a JSON document.
+type AuditChanges map[string]FieldChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	changesJson, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(changesJson), nil
+}
+
+func (c *AuditChanges) Scan(value interface{}) error {
+	switch changesJson := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(changesJson, c)
+	case string:
+		return json.Unmarshal([]byte(changesJson), c)
+	default:
+		return fmt.Errorf("cannot scan %T into AuditChanges", value)
+	}
+}
+
+// AuditFilter selects audit entries. Empty fields do not filter.
+type AuditFilter struct {
+	Entity    string     `json:"entity"`
+	EntityID  int        `json:"entity_id"`
+	Action    string     `json:"action"`
+	Actor     string     `json:"actor"`
+	RequestID string     `json:"request_id"`
+	From      *time.Time `json:"from"`
+	To        *time.Time `json:"to"` // exclusive
+	Page      int64      `json:"page"`
+	Limit     int64      `json:"limit"`
+}
+
+// Normalize keeps the page within bounds; the newest entries come first.
+func (f *AuditFilter) Normalize() {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.Limit < 1 {
+		f.Limit = defaultAuditPageSize
+	}
+	if f.Limit > maxAuditPageSize {
+		f.Limit = maxAuditPageSize
+	}
+}
+
+type AuditResponse struct {
+	Entries    []AuditEntry `json:"entries"`
+	Page       int          `json:"page"`
+	PageSize   int          `json:"page_size"`
+	TotalCount int64        `json:"total_count"`
+	TotalPages int          `json:"total_pages"`
+}
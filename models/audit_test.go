@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestAuditFilterNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    AuditFilter
+		wantPage  int64
+		wantLimit int64
+	}{
+		{name: "defaults", filter: AuditFilter{}, wantPage: 1, wantLimit: defaultAuditPageSize},
+		{name: "negative page", filter: AuditFilter{Page: -3, Limit: 10}, wantPage: 1, wantLimit: 10},
+		{name: "within bounds", filter: AuditFilter{Page: 4, Limit: 20}, wantPage: 4, wantLimit: 20},
+		{name: "page size capped", filter: AuditFilter{Page: 2, Limit: 10_000}, wantPage: 2, wantLimit: maxAuditPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Normalize()
+			if tt.filter.Page != tt.wantPage || tt.filter.Limit != tt.wantLimit {
+				t.Errorf("Normalize() = page %d limit %d, want page %d limit %d",
+					tt.filter.Page, tt.filter.Limit, tt.wantPage, tt.wantLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Kinds of cache entries that can be invalidated.
+const (
+	CacheEntityProduct        = "product"
+	CacheEntityProductCat     = "product_category"
+	CacheEntityCatalogVersion = "catalog_version"
+)
+
+// PendingCacheInvalidation is a cache invalidation that failed after its write committed. It is kept in the
+// database and retried until the cache accepts it.
+type PendingCacheInvalidation struct {
+	ID            int64     `json:"id"`
+	Entity        string    `json:"entity"`
+	EntityID      int       `json:"entity_id"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
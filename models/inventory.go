@@ -0,0 +1,162 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	InventoryPolicyDeny      = "deny"
+	InventoryPolicyBackorder = "backorder"
+	InventoryPolicyPreorder  = "preorder"
+
+	AvailabilityInStock    = "in_stock"
+	AvailabilityBackorder  = "backorder"
+	AvailabilityPreorder   = "preorder"
+	AvailabilityOutOfStock = "out_of_stock"
+
+	StockReasonReservation = "reservation"
+	StockReasonRelease     = "reservation release"
+	StockReasonCycleCount  = "cycle count"
+)
+
+var (
+	ErrProductNotFound    = errors.New("product not found")
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrInvalidStockPolicy = errors.New("invalid inventory policy")
+	ErrVersionConflict    = errors.New("product was modified concurrently")
+	ErrInvalidCategory    = errors.New("invalid category")
+	ErrCategoryInUse      = errors.New("category still has products")
+	ErrInvalidBundle      = errors.New("invalid bundle")
+	ErrProductInUse       = errors.New("product is a component of a bundle")
+	ErrSnapshotNotFound   = errors.New("no inventory snapshot on or before the date")
+)
+
+type StockAdjustment struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type StockManagementParameter struct {
+	Action    string `json:"action"` // e.g., "adjust", "reserve", "release"
+	ProductID int    `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// AllowsStock reports whether the product's inventory policy permits its stock to reach the given level.
+func (p *Product) AllowsStock(stock int) bool {
+	if stock >= 0 {
+		return true
+	}
+
+	switch p.InventoryPolicy {
+	case InventoryPolicyBackorder:
+		return stock >= -p.BackorderLimit
+	case InventoryPolicyPreorder:
+		return true
+	default:
+		return false
+	}
+}
+
+// ResolveAvailability derives the availability shown to customers from the stock and inventory policy.
+// Bundles take their availability from their components.
+func (p *Product) ResolveAvailability() string {
+	if p.Stock > 0 {
+		return AvailabilityInStock
+	}
+
+	if p.IsBundle {
+		return p.resolveBundleAvailability()
+	}
+
+	switch p.InventoryPolicy {
+	case InventoryPolicyBackorder:
+		if p.Stock > -p.BackorderLimit {
+			return AvailabilityBackorder
+		}
+	case InventoryPolicyPreorder:
+		return AvailabilityPreorder
+	}
+	return AvailabilityOutOfStock
+}
+
+type StockCount struct {
+	ProductID       int `json:"product_id"`
+	CountedQuantity int `json:"counted_quantity"`
+}
+
+type StockCountDiff struct {
+	ProductID       int `json:"product_id"`
+	CurrentStock    int `json:"current_stock"`
+	CountedQuantity int `json:"counted_quantity"`
+	Difference      int `json:"difference"`
+}
+
+type StockReconciliation struct {
+	DryRun            bool             `json:"dry_run"`
+	Items             []StockCountDiff `json:"items"`
+	UnknownProductIDs []int            `json:"unknown_product_ids"`
+	AdjustedCount     int              `json:"adjusted_count"`
+}
+
+type InventorySnapshot struct {
+	SnapshotDate time.Time `json:"snapshot_date"`
+	ProductID    int       `json:"product_id"`
+	ProductName  string    `json:"product_name"`
+	CategoryID   int       `json:"category_id"`
+	Stock        int       `json:"stock"`
+	UnitCost     float64   `json:"unit_cost"`
+}
+
+type CategoryValuation struct {
+	CategoryID   int     `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	TotalStock   int     `json:"total_stock"`
+	TotalValue   float64 `json:"total_value"`
+}
+
+type InventoryValuationReport struct {
+	Date       string              `json:"date"`
+	Categories []CategoryValuation `json:"categories"`
+	TotalValue float64             `json:"total_value"`
+}
+
+// ResolveBundleStock sets the stock of a bundle to the number of complete bundles its components can make.
+func (p *Product) ResolveBundleStock() {
+	stock := -1
+	for _, component := range p.Components {
+		if component.Product == nil || component.Quantity <= 0 {
+			stock = 0
+			break
+		}
+
+		available := max(component.Product.Stock, 0) / component.Quantity
+		if stock < 0 || available < stock {
+			stock = available
+		}
+	}
+	p.Stock = max(stock, 0)
+}
+
+func (p *Product) resolveBundleAvailability() string {
+	if len(p.Components) == 0 {
+		return AvailabilityOutOfStock
+	}
+
+	availability := AvailabilityBackorder
+	for _, component := range p.Components {
+		if component.Product == nil || !component.Product.AllowsStock(component.Product.Stock-component.Quantity) {
+			return AvailabilityOutOfStock
+		}
+
+		if component.Product.Stock < component.Quantity && component.Product.InventoryPolicy == InventoryPolicyPreorder {
+			availability = AvailabilityPreorder
+		}
+	}
+	return availability
+}
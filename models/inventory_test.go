@@ -0,0 +1,126 @@
+package models
+
+import "testing"
+
+func TestResolveBundleStock(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []BundleComponent
+		want       int
+	}{
+		{
+			name: "limited by the scarcest component",
+			components: []BundleComponent{
+				{Quantity: 2, Product: &Product{Stock: 9}},
+				{Quantity: 1, Product: &Product{Stock: 3}},
+			},
+			want: 3,
+		},
+		{
+			name: "incomplete bundles do not count",
+			components: []BundleComponent{
+				{Quantity: 4, Product: &Product{Stock: 7}},
+			},
+			want: 1,
+		},
+		{
+			name: "backordered components make no bundles",
+			components: []BundleComponent{
+				{Quantity: 1, Product: &Product{Stock: -5}},
+			},
+			want: 0,
+		},
+		{
+			name: "missing component makes no bundles",
+			components: []BundleComponent{
+				{Quantity: 1, Product: &Product{Stock: 5}},
+				{Quantity: 1},
+			},
+			want: 0,
+		},
+		{
+			name: "no components",
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := &Product{IsBundle: true, Stock: 100, Components: tt.components}
+			bundle.ResolveBundleStock()
+			if bundle.Stock != tt.want {
+				t.Errorf("ResolveBundleStock() set stock %d, want %d", bundle.Stock, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAvailability(t *testing.T) {
+	tests := []struct {
+		name    string
+		product Product
+		want    string
+	}{
+		{
+			name:    "in stock",
+			product: Product{Stock: 1, InventoryPolicy: InventoryPolicyDeny},
+			want:    AvailabilityInStock,
+		},
+		{
+			name:    "sold out",
+			product: Product{Stock: 0, InventoryPolicy: InventoryPolicyDeny},
+			want:    AvailabilityOutOfStock,
+		},
+		{
+			name:    "backorder within the limit",
+			product: Product{Stock: -2, InventoryPolicy: InventoryPolicyBackorder, BackorderLimit: 5},
+			want:    AvailabilityBackorder,
+		},
+		{
+			name:    "backorder limit reached",
+			product: Product{Stock: -5, InventoryPolicy: InventoryPolicyBackorder, BackorderLimit: 5},
+			want:    AvailabilityOutOfStock,
+		},
+		{
+			name:    "preorder",
+			product: Product{Stock: 0, InventoryPolicy: InventoryPolicyPreorder},
+			want:    AvailabilityPreorder,
+		},
+		{
+			name: "bundle of backorderable components",
+			product: Product{IsBundle: true, Components: []BundleComponent{
+				{Quantity: 2, Product: &Product{Stock: 1, InventoryPolicy: InventoryPolicyBackorder, BackorderLimit: 5}},
+				{Quantity: 1, Product: &Product{Stock: 4, InventoryPolicy: InventoryPolicyDeny}},
+			}},
+			want: AvailabilityBackorder,
+		},
+		{
+			name: "bundle with a preorder component short of stock",
+			product: Product{IsBundle: true, Components: []BundleComponent{
+				{Quantity: 1, Product: &Product{Stock: 0, InventoryPolicy: InventoryPolicyPreorder}},
+				{Quantity: 1, Product: &Product{Stock: 4, InventoryPolicy: InventoryPolicyDeny}},
+			}},
+			want: AvailabilityPreorder,
+		},
+		{
+			name: "bundle with a component that cannot be sold",
+			product: Product{IsBundle: true, Components: []BundleComponent{
+				{Quantity: 3, Product: &Product{Stock: 2, InventoryPolicy: InventoryPolicyDeny}},
+			}},
+			want: AvailabilityOutOfStock,
+		},
+		{
+			name:    "bundle without components",
+			product: Product{IsBundle: true},
+			want:    AvailabilityOutOfStock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.product.ResolveAvailability(); got != tt.want {
+				t.Errorf("ResolveAvailability() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
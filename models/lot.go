@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrLotNotFound   = errors.New("lot not found")
+	ErrLotNotTracked = errors.New("product is not lot tracked")
+)
+
+type ProductLot struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	LotNumber string    `json:"lot_number"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExpiringLot struct {
+	ProductLot
+	ProductName string `json:"product_name"`
+}
+
+type LotManagementParameter struct {
+	Action string `json:"action"` // e.g., "receive", "write_off"
+	ProductLot
+}
+
+// IsExpired reports whether the lot can no longer be allocated at the given time.
+func (l *ProductLot) IsExpired(at time.Time) bool {
+	return !l.ExpiresAt.After(at)
+}
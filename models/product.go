@@ -1,12 +1,39 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 type Product struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Stock       int     `json:"stock"`
-	CategoryID  int     `json:"category_id"`
-	Price       float64 `json:"price"`
+	ID                  int               `json:"id"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	Stock               int               `json:"stock"`
+	CategoryID          int               `json:"category_id"`
+	Price               float64           `json:"price"`
+	Cost                float64           `json:"cost"`                  // unit cost used for inventory valuation
+	InventoryPolicy     string            `json:"inventory_policy"`      // e.g., "deny", "backorder", "preorder"
+	BackorderLimit      int               `json:"backorder_limit"`       // max units that can be sold below zero
+	PreorderAvailableAt *time.Time        `json:"preorder_available_at"` // expected availability for pre-orders
+	IsBundle            bool              `json:"is_bundle"`
+	IsLotTracked        bool              `json:"is_lot_tracked"` // stock is held in lots with expiry dates
+	Version             int               `json:"version"`        // incremented on every write of the product
+	Components          []BundleComponent `json:"components,omitempty" gorm:"-"`
+	Availability        string            `json:"availability" gorm:"-"`
+
+	// filled by full-text searches only; snippets are plain text with the matched words between
+	// SearchHighlightStart and SearchHighlightStop
+	Rank        float64 `json:"rank,omitempty" gorm:"->"`
+	NameSnippet string  `json:"name_snippet,omitempty" gorm:"->"`
+	Snippet     string  `json:"snippet,omitempty" gorm:"->"`
+}
+
+type BundleComponent struct {
+	BundleID    int      `json:"-"`
+	ComponentID int      `json:"component_id"`
+	Quantity    int      `json:"quantity"` // units of the component in one bundle
+	Product     *Product `json:"product,omitempty" gorm:"-"`
 }
 
 type ProductCategory struct {
@@ -20,21 +47,91 @@ type ProductManagementParameter struct {
 }
 
 type ProductCategoryManagementParameter struct {
-	Action string `json:"action"`
+	Action     string `json:"action"`
+	ReassignTo int    `json:"reassign_to"` // category receiving the products of a deleted category
 	ProductCategory
 }
 
 type SearchProductParameter struct {
+	Query    string  `json:"q"` // full-text query over names and descriptions
 	Name     string  `json:"name"`
+	Fuzzy    bool    `json:"fuzzy"` // match the name by similarity, tolerating typos
 	Category string  `json:"category"`
 	MinPrice float64 `json:"min_price"`
 	MaxPrice float64 `json:"max_price"`
-	SortBy   string  `json:"sort_by"`  // e.g., "price", "name"
+	SortBy   string  `json:"sort_by"`  // e.g., "price", "name", "relevance"
 	OrderBy  string  `json:"order_by"` // e.g., "asc", "desc"
 	Limit    int64   `json:"limit"`    // Number of products to return
 	Page     int64   `json:"page"`     // Page number for pagination
 }
 
+// SearchRankColumn holds the full-text relevance of a product in search queries.
+const SearchRankColumn = "rank"
+
+// SearchHighlightStart and SearchHighlightStop enclose the matched words of search snippets. Snippets are plain
+// text taken from the catalog as stored, so clients escape them like any other text and render what is
+// between the markers highlighted. Both are private use characters, which never occur in catalog text.
+const (
+	SearchHighlightStart = "\ue000"
+	SearchHighlightStop  = "\ue001"
+)
+
+var searchSortColumns = map[string]string{
+	"relevance":     SearchRankColumn,
+	"name":          "product.name",
+	"price":         "product.price",
+	"stock":         "product.stock",
+	"id":            "product.id",
+	"product.name":  "product.name",
+	"product.price": "product.price",
+	"product.stock": "product.stock",
+	"product.id":    "product.id",
+}
+
+// Normalize trims the filters and resolves the sorting so equivalent searches are described by equal
+// parameters. Full-text and fuzzy searches sort by relevance unless told otherwise; unknown sort columns, and
+// relevance without such a search, fall back to the product name.
+func (p *SearchProductParameter) Normalize() {
+	p.Query = strings.TrimSpace(p.Query)
+	p.Name = strings.TrimSpace(p.Name)
+	p.Category = strings.TrimSpace(p.Category)
+
+	ranked := p.Query != "" || (p.Fuzzy && p.Name != "")
+	sortBy := strings.ToLower(strings.TrimSpace(p.SortBy))
+	if sortBy == "" && ranked {
+		sortBy = "relevance"
+	}
+
+	sortColumn, ok := searchSortColumns[sortBy]
+	if !ok || (sortColumn == SearchRankColumn && !ranked) {
+		sortColumn = "product.name"
+	}
+	p.SortBy = sortColumn
+
+	if p.OrderBy == "" || (p.OrderBy != "asc" && p.OrderBy != "desc") {
+		p.OrderBy = "asc"
+		if sortColumn == SearchRankColumn {
+			p.OrderBy = "desc"
+		}
+	}
+}
+
+// SearchProductResult is one page of search results. DidYouMean is only set when nothing was found.
+type SearchProductResult struct {
+	Products   []Product `json:"products"`
+	TotalCount int64     `json:"total_count"`
+	DidYouMean string    `json:"did_you_mean,omitempty"`
+}
+
+type ProductBatchParameter struct {
+	IDs []int `json:"ids"`
+}
+
+type ProductBatchResponse struct {
+	Products []Product `json:"products"`
+	NotFound []int     `json:"not_found"`
+}
+
 type SearchProductResponse struct {
 	Products    []Product `json:"products"`
 	Page        int       `json:"page"`
@@ -43,4 +140,5 @@ type SearchProductResponse struct {
 	TotalPages  int       `json:"total_pages"`
 	NextPage    bool      `json:"next_page"`
 	NextPageURL *string   `json:"next_page_url"`
+	DidYouMean  string    `json:"did_you_mean,omitempty"` // closest product name when nothing matched
 }
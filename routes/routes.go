@@ -6,15 +6,29 @@ import (
 	"product_commerce/middleware"
 )
 
-func SetupRoutes(router *gin.Engine, productHandler handler.ProductHandler) {
-	router.Use(middleware.RequestLogger())
+func SetupRoutes(router *gin.Engine, productHandler handler.ProductHandler, monitoringHandler handler.MonitoringHandler) {
+	router.Use(middleware.RequestLogger(), middleware.ReadYourWrites())
 
 	router.POST("v1/product_category", productHandler.ProductCategoryManagement)
 	router.GET("v1/product_category/:id", productHandler.GetProductCategoryById)
 
 	router.POST("v1/product", productHandler.ProductManagement)
 	router.GET("v1/product/:id", productHandler.GetProductById)
+	router.GET("v1/product/:id/history", productHandler.GetProductHistory)
+	router.POST("v1/product/stock", productHandler.StockManagement)
+	router.POST("v1/product/lot", productHandler.LotManagement)
 
+	router.GET("v1/products", productHandler.GetProductsByIds)
+	router.POST("v1/products", productHandler.GetProductsByIds)
 	router.GET("v1/products/search", productHandler.SearchProduct)
 
+	router.POST("v1/inventory/reconcile", productHandler.ReconcileInventory)
+	router.GET("v1/inventory/snapshot", productHandler.GetInventorySnapshot)
+	router.GET("v1/inventory/valuation", productHandler.GetInventoryValuation)
+	router.GET("v1/inventory/lots/expiring", productHandler.GetExpiringLots)
+
+	router.GET("v1/audit", productHandler.GetAuditLog)
+
+	router.GET("v1/monitoring/database", monitoringHandler.GetDatabaseStats)
+
 }
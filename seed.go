@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"product_commerce/cmd/product/job"
+	"product_commerce/cmd/product/repository"
+	"product_commerce/cmd/product/resource"
+	"product_commerce/config"
+	"product_commerce/infra/log"
+)
+
+// seed generates a synthetic catalog and exits. Flags override the seed section of the config.
+func seed(args []string) {
+	cfg := config.LoadConfig()
+	seedCfg := cfg.Seed
+
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.IntVar(&seedCfg.Categories, "categories", seedCfg.Categories, "number of categories to generate")
+	flags.IntVar(&seedCfg.Products, "products", seedCfg.Products, "number of products to generate")
+	flags.Int64Var(&seedCfg.Seed, "seed", seedCfg.Seed, "random seed, the same seed generates the same catalog")
+	flags.IntVar(&seedCfg.BatchSize, "batch-size", seedCfg.BatchSize, "rows inserted per transaction")
+	warm := flags.Bool("warm", false, "warm the Redis cache once the catalog is seeded")
+	_ = flags.Parse(args)
+
+	log.SetupLogger()
+	if *warm && cfg.Cache.Driver == resource.CacheDriverMemory {
+		log.Logger.Fatal("-warm needs a shared cache, the memory cache driver is filled by serve only")
+	}
+	db := resource.InitDB(&cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// the seeded entities are attributed to the seed in the audit log
+	ctx = context.WithValue(ctx, "actor", "seed")
+
+	productRepository := repository.NewProductRepo(db, nil, cfg.Search.FuzzyThreshold)
+	var productStore repository.ProductStore = productRepository
+	var productCache repository.ProductCache
+	if cfg.Cache.Driver != resource.CacheDriverMemory {
+		// a running service may have cached searches, or ids of the new products as missing
+		productCache = newRedisCache(&cfg, resource.InitRedis(&cfg))
+		productStore = repository.NewCachedProductStore(productRepository, productCache, 0)
+	}
+
+	_, err := job.NewCatalogSeedJob(productStore, seedCfg).Run(ctx)
+	if err != nil {
+		log.Logger.Errorf("catalog seed failed: %v", err)
+		stop()
+		os.Exit(1)
+	}
+
+	if *warm {
+		_, err = job.NewCacheWarmupJob(productRepository, productCache, cfg.Cache.Warmup).Run(ctx)
+		if err != nil {
+			log.Logger.Errorf("cache warm-up after seeding failed: %v", err)
+			stop()
+			os.Exit(1)
+		}
+	}
+}
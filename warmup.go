@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"product_commerce/cmd/product/job"
+	"product_commerce/cmd/product/repository"
+	"product_commerce/cmd/product/resource"
+	"product_commerce/config"
+	"product_commerce/infra/log"
+)
+
+// warmup fills Redis once and exits. Flags override the cache.warmup section of the config.
+func warmup(args []string) {
+	cfg := config.LoadConfig()
+	warmupCfg := cfg.Cache.Warmup
+
+	flags := flag.NewFlagSet("warmup", flag.ExitOnError)
+	flags.IntVar(&warmupCfg.TopN, "top", warmupCfg.TopN, "number of products to load")
+	flags.StringVar(&warmupCfg.Criteria, "criteria", warmupCfg.Criteria, "ranking of the products to load: reserved, newest, stock or price")
+	flags.IntVar(&warmupCfg.BatchSize, "batch-size", warmupCfg.BatchSize, "products read from the database per batch")
+	flags.Float64Var(&warmupCfg.BatchesPerSecond, "rate", warmupCfg.BatchesPerSecond, "maximum batches read per second, 0 for no limit")
+	_ = flags.Parse(args)
+
+	log.SetupLogger()
+	if cfg.Cache.Driver == resource.CacheDriverMemory {
+		// the memory cache lives in the serving process, set cache.warmup.on_startup there instead
+		log.Logger.Fatal("warmup needs a shared cache, the memory cache driver is filled by serve only")
+	}
+	redis := resource.InitRedis(&cfg)
+	db := resource.InitDB(&cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	productRepository := repository.NewProductRepo(db, nil, cfg.Search.FuzzyThreshold)
+	productCache := newRedisCache(&cfg, redis)
+	_, err := job.NewCacheWarmupJob(productRepository, productCache, warmupCfg).Run(ctx)
+	if err != nil {
+		log.Logger.Errorf("cache warm-up failed: %v", err)
+		stop()
+		os.Exit(1)
+	}
+}